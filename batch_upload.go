@@ -0,0 +1,347 @@
+package goldenimage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// dataSourceGVR is the KubeVirt DataSource resource that, once pointed at a
+// PVC, surfaces it in the OpenShift Virtualization "Template golden images"
+// UI as a selectable boot source.
+var dataSourceGVR = schema.GroupVersionResource{
+	Group:    "cdi.kubevirt.io",
+	Version:  "v1beta1",
+	Resource: "datasources",
+}
+
+// ImageSpec describes one image in a batch upload: its local path, the
+// DataVolume/PVC name to create it under, and optional overrides of the
+// size this uploader would otherwise auto-detect and the labels applied to
+// the resulting DataVolume.
+type ImageSpec struct {
+	LocalPath string
+	PVCName   string
+	PVCSize   string
+	Labels    map[string]string
+}
+
+// UploadBatch uploads multiple images through a single shared ephemeral
+// image server instead of provisioning one per image: the server is
+// prepared once, every image is pushed to it concurrently (bounded by
+// chunkWorkers), and a single watch on the DataVolume GVR waits for every
+// DataVolume to succeed instead of polling each one in turn. The server is
+// torn down once every import has finished, whether or not all of them
+// succeeded. When createDataSources is true, a DataSource is created for
+// each successfully imported image once its DataVolume succeeds.
+func (u *GoldenImageUploader) UploadBatch(ctx context.Context, specs []ImageSpec, createDataSources bool) error {
+	if len(specs) == 0 {
+		return fmt.Errorf("no images given")
+	}
+
+	server := u.imageServer
+	if server == nil {
+		server = newNginxServer(u)
+	}
+	nginx, ok := server.(*nginxServer)
+	if !ok {
+		return fmt.Errorf("batch upload requires the nginx image server backend, got %T", server)
+	}
+
+	fmt.Printf("Preparing shared image server for %d images...\n", len(specs))
+	if err := nginx.Prepare(ctx); err != nil {
+		return fmt.Errorf("preparing image server: %w", err)
+	}
+	defer nginx.Cleanup(ctx)
+
+	workers := u.chunkWorkers
+	if workers <= 0 {
+		workers = defaultChunkWorkers
+	}
+
+	var (
+		wg       sync.WaitGroup
+		sem      = make(chan struct{}, workers)
+		mu       sync.Mutex
+		pushErrs []error
+	)
+
+	for i := range specs {
+		spec := specs[i]
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := u.pushAndCreateDataVolume(ctx, nginx, spec); err != nil {
+				mu.Lock()
+				pushErrs = append(pushErrs, fmt.Errorf("%s: %w", spec.PVCName, err))
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(pushErrs) > 0 {
+		return fmt.Errorf("pushing images: %v", pushErrs)
+	}
+
+	names := make([]string, len(specs))
+	for i, spec := range specs {
+		names[i] = spec.PVCName
+	}
+
+	fmt.Println("Waiting for all DataVolumes to complete...")
+	results, err := u.watchDataVolumesUntilDone(ctx, names)
+	if err != nil {
+		return fmt.Errorf("waiting for DataVolumes: %w", err)
+	}
+
+	var failed []error
+	for _, spec := range specs {
+		if err := results[spec.PVCName]; err != nil {
+			failed = append(failed, fmt.Errorf("%s: %w", spec.PVCName, err))
+			continue
+		}
+		if createDataSources {
+			if err := u.createDataSource(ctx, spec); err != nil {
+				failed = append(failed, fmt.Errorf("%s: creating DataSource: %w", spec.PVCName, err))
+			}
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("batch upload had failures: %v", failed)
+	}
+
+	fmt.Printf("Batch upload of %d images completed successfully\n", len(specs))
+	return nil
+}
+
+// pushAndCreateDataVolume sizes (if needed), pushes, and creates the
+// DataVolume for a single image in the batch, using the shared server's web
+// root keyed by PVC name so concurrent pushes don't collide.
+func (u *GoldenImageUploader) pushAndCreateDataVolume(ctx context.Context, nginx *nginxServer, spec ImageSpec) error {
+	file, err := os.Open(spec.LocalPath)
+	if err != nil {
+		return fmt.Errorf("opening local file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("stat local file: %w", err)
+	}
+
+	pvcSize := spec.PVCSize
+	if pvcSize == "" {
+		sizeInfo, err := InspectImageSize(ctx, u.dynamicClient, spec.LocalPath, u.storageClass)
+		if err != nil {
+			return fmt.Errorf("sizing PVC: %w", err)
+		}
+		pvcSize = sizeInfo.RecommendedSize
+	}
+
+	remoteName := spec.PVCName + ".qcow2"
+	if err := nginx.pushNamed(ctx, file, info.Size(), remoteName); err != nil {
+		return fmt.Errorf("pushing image: %w", err)
+	}
+
+	return u.createDataVolumeNamed(ctx, spec.PVCName, pvcSize, spec.Labels, nginx.sourceFor(remoteName))
+}
+
+// createDataVolumeNamed creates a DataVolume for one image in a batch,
+// mirroring createDataVolumeWithSource but for a PVC name other than the
+// uploader's own (each image in a batch gets its own PVC name).
+func (u *GoldenImageUploader) createDataVolumeNamed(ctx context.Context, pvcName, pvcSize string, extraLabels map[string]string, source map[string]interface{}) error {
+	metadata := map[string]interface{}{
+		"name":        pvcName,
+		"namespace":   u.namespace,
+		"annotations": annotationsForDataVolume(""),
+	}
+	if len(extraLabels) > 0 {
+		labelsMap := make(map[string]interface{}, len(extraLabels))
+		for k, v := range extraLabels {
+			labelsMap[k] = v
+		}
+		metadata["labels"] = labelsMap
+	}
+
+	dv := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "cdi.kubevirt.io/v1beta1",
+			"kind":       "DataVolume",
+			"metadata":   metadata,
+			"spec": map[string]interface{}{
+				"source": source,
+				"storage": map[string]interface{}{
+					"resources": map[string]interface{}{
+						"requests": map[string]interface{}{
+							"storage": pvcSize,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if u.storageClass != "" {
+		spec := dv.Object["spec"].(map[string]interface{})
+		storage := spec["storage"].(map[string]interface{})
+		storage["storageClassName"] = u.storageClass
+	}
+
+	_, err := u.dynamicClient.Resource(dataVolumeGVR).Namespace(u.namespace).Create(ctx, dv, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("creating DataVolume: %w", err)
+	}
+	return nil
+}
+
+// watchDataVolumesUntilDone watches the DataVolume GVR in the uploader's
+// namespace and returns once every name in names has reached Succeeded or
+// Failed, mapping each name to nil (succeeded) or a *DataVolumePhaseError
+// (failed). A single watch replaces the per-DataVolume polling the
+// single-image workflows use, since the apiserver push is cheap to share
+// across an entire batch. The apiserver closing the watch (routine, well
+// within a 60-minute import window) is not treated as fatal: a closed
+// channel re-lists to pick up anything missed and re-establishes the watch
+// from there, the same recovery a real informer would do.
+func (u *GoldenImageUploader) watchDataVolumesUntilDone(ctx context.Context, names []string) (map[string]error, error) {
+	pending := make(map[string]bool, len(names))
+	for _, name := range names {
+		pending[name] = true
+	}
+	results := make(map[string]error, len(names))
+
+	for len(pending) > 0 {
+		list, err := u.dynamicClient.Resource(dataVolumeGVR).Namespace(u.namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("listing DataVolumes: %w", err)
+		}
+		for i := range list.Items {
+			applyDataVolumePhase(&list.Items[i], pending, results)
+		}
+		if len(pending) == 0 {
+			break
+		}
+
+		w, err := u.dynamicClient.Resource(dataVolumeGVR).Namespace(u.namespace).Watch(ctx, metav1.ListOptions{
+			ResourceVersion: list.GetResourceVersion(),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("watching DataVolumes: %w", err)
+		}
+
+		finished, err := drainDataVolumeWatch(ctx, w, pending, results)
+		w.Stop()
+		if err != nil {
+			return nil, err
+		}
+		if finished {
+			break
+		}
+		// w.ResultChan() closed with pending DataVolumes still outstanding:
+		// back off briefly so a watch that keeps closing immediately (e.g. a
+		// load balancer with a short idle timeout) can't hot-loop List+Watch
+		// against the apiserver, then re-list and re-watch instead of
+		// failing the batch.
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+
+	return results, nil
+}
+
+// drainDataVolumeWatch consumes events from an established watch until
+// either every pending DataVolume resolves (finished=true) or the apiserver
+// closes the channel (finished=false, err=nil). A watch.Error event or a
+// cancelled context is returned as an error.
+func drainDataVolumeWatch(ctx context.Context, w watch.Interface, pending map[string]bool, results map[string]error) (bool, error) {
+	for len(pending) > 0 {
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return false, nil
+			}
+			if event.Type == watch.Error {
+				return false, fmt.Errorf("DataVolume watch error: %v", event.Object)
+			}
+
+			obj, ok := event.Object.(*unstructured.Unstructured)
+			if !ok {
+				continue
+			}
+			applyDataVolumePhase(obj, pending, results)
+		}
+	}
+	return true, nil
+}
+
+// applyDataVolumePhase records obj's terminal phase into results and drops
+// it from pending, if obj is one of the names being waited on and has
+// reached Succeeded or Failed.
+func applyDataVolumePhase(obj *unstructured.Unstructured, pending map[string]bool, results map[string]error) {
+	name := obj.GetName()
+	if !pending[name] {
+		return
+	}
+	status, ok := obj.Object["status"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	phase, _ := status["phase"].(string)
+
+	switch phase {
+	case DVPhaseSucceeded:
+		fmt.Printf("DataVolume %s succeeded\n", name)
+		results[name] = nil
+		delete(pending, name)
+	case DVPhaseFailed:
+		fmt.Printf("DataVolume %s failed\n", name)
+		results[name] = &DataVolumePhaseError{Name: name, Phase: phase, Wanted: DVPhaseSucceeded}
+		delete(pending, name)
+	}
+}
+
+// createDataSource creates a KubeVirt DataSource pointing at the PVC an
+// image was imported into, so it immediately shows up as a boot source in
+// the OpenShift Virtualization console.
+func (u *GoldenImageUploader) createDataSource(ctx context.Context, spec ImageSpec) error {
+	ds := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "cdi.kubevirt.io/v1beta1",
+			"kind":       "DataSource",
+			"metadata": map[string]interface{}{
+				"name":      spec.PVCName,
+				"namespace": u.namespace,
+			},
+			"spec": map[string]interface{}{
+				"source": map[string]interface{}{
+					"pvc": map[string]interface{}{
+						"name":      spec.PVCName,
+						"namespace": u.namespace,
+					},
+				},
+			},
+		},
+	}
+
+	_, err := u.dynamicClient.Resource(dataSourceGVR).Namespace(u.namespace).Create(ctx, ds, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("creating DataSource: %w", err)
+	}
+	return nil
+}
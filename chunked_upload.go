@@ -0,0 +1,429 @@
+package goldenimage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+const (
+	// defaultChunkSize is used when the uploader is not configured with an
+	// explicit chunk size.
+	defaultChunkSize = 64 * 1024 * 1024 // 64 MiB
+
+	// defaultChunkWorkers bounds the number of concurrent exec sessions used
+	// to saturate bandwidth without overwhelming the apiserver.
+	defaultChunkWorkers = 4
+
+	// ddBlockSize is the block size passed to dd on the pod side; chunk
+	// sizes must be a multiple of it so seek/count land on block boundaries.
+	ddBlockSize = 1024 * 1024 // 1 MiB
+
+	remoteImagePath    = "/usr/share/nginx/html/disk.qcow2"
+	remoteManifestPath = "/usr/share/nginx/html/disk.qcow2.manifest"
+	remoteChecksumPath = "/usr/share/nginx/html/disk.qcow2.sha256"
+)
+
+// uploadState tracks per-chunk upload progress for a resumable transfer. It
+// is keyed by the SHA-256 of the full local image so that resuming against a
+// different file is detected rather than silently corrupting the target.
+type uploadState struct {
+	ImageSHA256     string   `json:"imageSha256"`
+	ChunkSize       int64    `json:"chunkSize"`
+	TotalChunks     int      `json:"totalChunks"`
+	ChunkSHA256     []string `json:"chunkSha256"`
+	CompletedChunks []bool   `json:"completedChunks"`
+}
+
+// StreamImageChunked streams localImagePath to the ephemeral image server in
+// fixed-size chunks, each delivered over its own exec session. Progress is
+// persisted to a local state file so a failed exec session only costs the
+// in-flight chunk rather than the whole transfer: re-invoking resumes at the
+// first chunk not already marked complete in that local state. Resume relies
+// solely on that local state, not a remote manifest: the server's
+// NetworkPolicy intentionally admits only CDI importer pods, so this tool's
+// own HTTP requests can't read anything back from it, only exec into it.
+// Chunks are uploaded concurrently, bounded by a worker pool, and the
+// transfer finishes with a full SHA-256 validation against the assembled
+// remote file before a sidecar .sha256 is published.
+func (u *GoldenImageUploader) StreamImageChunked(ctx context.Context, localImagePath string) error {
+	chunkSize := u.chunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+	workers := u.chunkWorkers
+	if workers <= 0 {
+		workers = defaultChunkWorkers
+	}
+
+	info, err := os.Stat(localImagePath)
+	if err != nil {
+		return fmt.Errorf("stat local file: %w", err)
+	}
+	totalSize := info.Size()
+	totalChunks := int((totalSize + chunkSize - 1) / chunkSize)
+
+	fmt.Printf("Image size: %d bytes (%.2f GB), hashing for resume support...\n",
+		totalSize, float64(totalSize)/(1024*1024*1024))
+	imageHash, err := fileSHA256(localImagePath)
+	if err != nil {
+		return fmt.Errorf("hashing local image: %w", err)
+	}
+
+	statePath := u.stateFilePath()
+	state, err := loadUploadState(statePath)
+	if err != nil {
+		return err
+	}
+	if state == nil || state.ImageSHA256 != imageHash || state.ChunkSize != chunkSize {
+		state = &uploadState{
+			ImageSHA256:     imageHash,
+			ChunkSize:       chunkSize,
+			TotalChunks:     totalChunks,
+			ChunkSHA256:     make([]string, totalChunks),
+			CompletedChunks: make([]bool, totalChunks),
+		}
+	}
+
+	if err := u.ensureRemoteFileAllocated(ctx, totalSize); err != nil {
+		return fmt.Errorf("allocating remote file: %w", err)
+	}
+
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+		sem      = make(chan struct{}, workers)
+		uploaded int
+	)
+
+	for i := 0; i < totalChunks; i++ {
+		offset := int64(i) * chunkSize
+		size := chunkSize
+		if offset+size > totalSize {
+			size = totalSize - offset
+		}
+
+		hash, err := chunkSHA256(localImagePath, offset, size)
+		if err != nil {
+			return fmt.Errorf("hashing chunk %d: %w", i, err)
+		}
+		state.ChunkSHA256[i] = hash
+
+		if state.CompletedChunks[i] {
+			continue
+		}
+
+		index, chunkOffset, chunkSizeBytes, chunkHash := i, offset, size, hash
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := u.uploadChunk(ctx, localImagePath, index, chunkOffset, chunkSizeBytes); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("uploading chunk %d: %w", index, err)
+				}
+				mu.Unlock()
+				return
+			}
+
+			mu.Lock()
+			state.CompletedChunks[index] = true
+			uploaded++
+			n := uploaded
+			_ = saveUploadState(statePath, state)
+			mu.Unlock()
+			fmt.Printf("Uploaded chunk %d/%d (%d/%d done, sha256 %s)\n",
+				index+1, totalChunks, n, totalChunks, chunkHash[:12])
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	if err := saveUploadState(statePath, state); err != nil {
+		return err
+	}
+
+	fmt.Println("Validating complete transfer against local SHA-256...")
+	if err := u.verifyRemoteChecksum(ctx, imageHash); err != nil {
+		return fmt.Errorf("verifying remote image: %w", err)
+	}
+	if err := u.writeRemoteChecksum(ctx, imageHash, state); err != nil {
+		return fmt.Errorf("writing remote checksum: %w", err)
+	}
+
+	u.imageChecksum = imageHash
+	_ = os.Remove(statePath)
+	return nil
+}
+
+// stateFilePath returns the local path used to persist chunk upload progress
+// for this uploader's target PVC.
+func (u *GoldenImageUploader) stateFilePath() string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf(".golden-image-upload-%s-%s.state", u.namespace, u.pvcName))
+}
+
+// loadUploadState reads a previously persisted upload state, returning a nil
+// state (not an error) if no state file exists yet.
+func loadUploadState(path string) (*uploadState, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading upload state: %w", err)
+	}
+	var st uploadState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("parsing upload state: %w", err)
+	}
+	return &st, nil
+}
+
+// saveUploadState persists upload progress so a retry can resume.
+func saveUploadState(path string, st *uploadState) error {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling upload state: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("writing upload state: %w", err)
+	}
+	return nil
+}
+
+// fileSHA256 computes the SHA-256 of an entire local file.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// chunkSHA256 computes the SHA-256 of a byte range of a local file.
+func chunkSHA256(path string, offset, size int64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.CopyN(h, f, size); err != nil && err != io.EOF {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// uploadChunk writes one chunk of the local file into the remote disk image
+// at the matching offset using dd conv=notrunc so unrelated bytes already on
+// disk (from a prior run) are left alone.
+func (u *GoldenImageUploader) uploadChunk(ctx context.Context, localImagePath string, index int, offset, size int64) error {
+	f, err := os.Open(localImagePath)
+	if err != nil {
+		return fmt.Errorf("opening local file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("seeking to offset %d: %w", offset, err)
+	}
+	chunkReader := io.LimitReader(f, size)
+
+	seekBlocks := offset / ddBlockSize
+	countBlocks := (size + ddBlockSize - 1) / ddBlockSize
+
+	req := u.k8sClient.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Name(serverPodName).
+		Namespace(u.namespace).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: "nginx",
+			Command: []string{"dd",
+				fmt.Sprintf("of=%s", remoteImagePath),
+				"conv=notrunc",
+				fmt.Sprintf("bs=%d", ddBlockSize),
+				fmt.Sprintf("seek=%d", seekBlocks),
+				fmt.Sprintf("count=%d", countBlocks),
+			},
+			Stdin:  true,
+			Stdout: true,
+			Stderr: true,
+		}, scheme.ParameterCodec)
+
+	exec, err := remotecommand.NewSPDYExecutor(u.restConfig, "POST", req.URL())
+	if err != nil {
+		return fmt.Errorf("creating executor: %w", err)
+	}
+
+	return exec.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdin:  chunkReader,
+		Stdout: io.Discard,
+		Stderr: os.Stderr,
+	})
+}
+
+// ensureRemoteFileAllocated pre-sizes the remote disk image so that chunk
+// writes landing past the current end of file via dd seek= behave
+// predictably, including on a fresh server pod.
+func (u *GoldenImageUploader) ensureRemoteFileAllocated(ctx context.Context, size int64) error {
+	return u.execOnServer(ctx, []string{"sh", "-c",
+		fmt.Sprintf("touch %s && truncate -s %d %s", remoteImagePath, size, remoteImagePath)})
+}
+
+// verifyRemoteChecksum recomputes the SHA-256 of the assembled disk.qcow2 on
+// the server pod itself and compares it against imageHash, so a short or
+// corrupt dd write is caught here rather than surfacing later as a CDI
+// import failure.
+func (u *GoldenImageUploader) verifyRemoteChecksum(ctx context.Context, imageHash string) error {
+	out, err := u.execOnServerOutput(ctx, []string{"sh", "-c", fmt.Sprintf("sha256sum %s", remoteImagePath)})
+	if err != nil {
+		return fmt.Errorf("computing remote checksum: %w", err)
+	}
+
+	fields := strings.Fields(out)
+	if len(fields) == 0 {
+		return fmt.Errorf("empty sha256sum output from server pod")
+	}
+	if remoteHash := fields[0]; remoteHash != imageHash {
+		return fmt.Errorf("remote checksum %s does not match local checksum %s", remoteHash, imageHash)
+	}
+	return nil
+}
+
+// writeRemoteChecksum writes the final chunk manifest and a full-image
+// .sha256 sidecar file to the server pod once every chunk has landed. The
+// manifest is a diagnostic record of what was transferred, not something
+// this tool reads back: the server's NetworkPolicy only admits CDI importer
+// pods, so this tool's own requests can't fetch it on a later resume.
+func (u *GoldenImageUploader) writeRemoteChecksum(ctx context.Context, imageHash string, state *uploadState) error {
+	manifest := make(map[string]string, len(state.ChunkSHA256))
+	for i, hash := range state.ChunkSHA256 {
+		manifest[fmt.Sprintf("%d", i)] = hash
+	}
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("marshaling chunk manifest: %w", err)
+	}
+
+	if err := u.writeRemoteFile(ctx, remoteManifestPath, string(manifestJSON)); err != nil {
+		return fmt.Errorf("writing chunk manifest: %w", err)
+	}
+
+	checksumLine := fmt.Sprintf("%s  disk.qcow2\n", imageHash)
+	if err := u.writeRemoteFile(ctx, remoteChecksumPath, checksumLine); err != nil {
+		return fmt.Errorf("writing sha256 sidecar: %w", err)
+	}
+
+	return nil
+}
+
+// writeRemoteFile writes content to a file on the server pod over a single
+// exec session.
+func (u *GoldenImageUploader) writeRemoteFile(ctx context.Context, remotePath, content string) error {
+	req := u.k8sClient.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Name(serverPodName).
+		Namespace(u.namespace).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: "nginx",
+			Command:   []string{"sh", "-c", fmt.Sprintf("cat > %s", remotePath)},
+			Stdin:     true,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	exec, err := remotecommand.NewSPDYExecutor(u.restConfig, "POST", req.URL())
+	if err != nil {
+		return fmt.Errorf("creating executor: %w", err)
+	}
+
+	return exec.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdin:  strings.NewReader(content),
+		Stdout: io.Discard,
+		Stderr: os.Stderr,
+	})
+}
+
+// execOnServer runs a command on the server pod with no stdin, discarding
+// stdout but surfacing stderr for diagnostics.
+func (u *GoldenImageUploader) execOnServer(ctx context.Context, command []string) error {
+	req := u.k8sClient.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Name(serverPodName).
+		Namespace(u.namespace).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: "nginx",
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	exec, err := remotecommand.NewSPDYExecutor(u.restConfig, "POST", req.URL())
+	if err != nil {
+		return fmt.Errorf("creating executor: %w", err)
+	}
+
+	return exec.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdout: io.Discard,
+		Stderr: os.Stderr,
+	})
+}
+
+// execOnServerOutput runs a command on the server pod with no stdin and
+// returns its captured stdout, surfacing stderr for diagnostics.
+func (u *GoldenImageUploader) execOnServerOutput(ctx context.Context, command []string) (string, error) {
+	req := u.k8sClient.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Name(serverPodName).
+		Namespace(u.namespace).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: "nginx",
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	exec, err := remotecommand.NewSPDYExecutor(u.restConfig, "POST", req.URL())
+	if err != nil {
+		return "", fmt.Errorf("creating executor: %w", err)
+	}
+
+	var stdout bytes.Buffer
+	err = exec.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdout: &stdout,
+		Stderr: os.Stderr,
+	})
+	return stdout.String(), err
+}
@@ -8,24 +8,71 @@ import (
 	"time"
 
 	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/yaml"
 
 	"example.com/goldenimage"
 )
 
+// manifestImage is the YAML shape of one entry in a --manifest file.
+type manifestImage struct {
+	Path   string            `json:"path"`
+	Name   string            `json:"name"`
+	Size   string            `json:"size,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// manifest is the YAML shape of a --manifest file accepted by UploadBatch.
+type manifest struct {
+	Images []manifestImage `json:"images"`
+}
+
+// loadManifest reads and parses a --manifest file into the ImageSpecs
+// UploadBatch expects.
+func loadManifest(path string) ([]goldenimage.ImageSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest: %w", err)
+	}
+
+	var m manifest
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parsing manifest: %w", err)
+	}
+
+	specs := make([]goldenimage.ImageSpec, len(m.Images))
+	for i, img := range m.Images {
+		specs[i] = goldenimage.ImageSpec{
+			LocalPath: img.Path,
+			PVCName:   img.Name,
+			PVCSize:   img.Size,
+			Labels:    img.Labels,
+		}
+	}
+	return specs, nil
+}
+
 func main() {
 	// Parse command line flags
 	kubeconfig := flag.String("kubeconfig", os.Getenv("KUBECONFIG"), "Path to kubeconfig file")
 	namespace := flag.String("namespace", "", "Target namespace for golden image")
 	pvcName := flag.String("name", "", "Name for the DataVolume/PVC")
-	pvcSize := flag.String("size", "10Gi", "Size of the PVC")
+	pvcSize := flag.String("size", "", "Size of the PVC (auto-detected from the image when omitted)")
 	storageClass := flag.String("storage-class", "", "Storage class (optional)")
 	imagePath := flag.String("image-path", "", "Path to local disk image")
+	chunkSizeMB := flag.Int64("chunk-size-mb", 64, "Chunk size in MiB for resumable uploads")
+	chunkWorkers := flag.Int("chunk-workers", 4, "Number of concurrent chunk upload workers")
+	uploadProxyURL := flag.String("upload-proxy-url", "", "Base URL of the CDI upload proxy (overrides in-cluster service discovery)")
+	insecureTLS := flag.Bool("insecure-tls", false, "Skip TLS verification when talking to the upload proxy")
+	sourceBackend := flag.String("source-backend", "http", "Ephemeral image server backend for UDN namespaces: http, registry, or s3 (registry and s3 require this tool to run with in-cluster network reachability to the namespace)")
+	manifestPath := flag.String("manifest", "", "Path to a YAML manifest of images for batch upload (overrides --name/--image-path/--size)")
+	createDataSources := flag.Bool("create-data-sources", false, "Create a KubeVirt DataSource per image after a successful batch upload")
 
 	flag.Parse()
 
 	// Validate required flags
-	if *namespace == "" || *pvcName == "" || *imagePath == "" {
+	if *namespace == "" || (*manifestPath == "" && (*pvcName == "" || *imagePath == "")) {
 		fmt.Println("Usage: golden-image-upload --namespace <ns> --name <name> --image-path <path>")
+		fmt.Println("   or: golden-image-upload --namespace <ns> --manifest <file.yaml>")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
@@ -37,6 +84,12 @@ func main() {
 		os.Exit(1)
 	}
 
+	backendOpt, err := goldenimage.WithSourceBackend(*sourceBackend)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Create uploader
 	uploader, err := goldenimage.NewGoldenImageUploader(
 		config,
@@ -44,6 +97,11 @@ func main() {
 		*pvcName,
 		*pvcSize,
 		*storageClass,
+		*chunkSizeMB*1024*1024,
+		*chunkWorkers,
+		*uploadProxyURL,
+		*insecureTLS,
+		backendOpt,
 	)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error creating uploader: %v\n", err)
@@ -54,7 +112,17 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Hour)
 	defer cancel()
 
-	if err := uploader.Upload(ctx, *imagePath); err != nil {
+	if *manifestPath != "" {
+		specs, err := loadManifest(*manifestPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading manifest: %v\n", err)
+			os.Exit(1)
+		}
+		if err := uploader.UploadBatch(ctx, specs, *createDataSources); err != nil {
+			fmt.Fprintf(os.Stderr, "Error uploading batch: %v\n", err)
+			os.Exit(1)
+		}
+	} else if err := uploader.Upload(ctx, *imagePath); err != nil {
 		fmt.Fprintf(os.Stderr, "Error uploading image: %v\n", err)
 		os.Exit(1)
 	}
@@ -0,0 +1,41 @@
+package goldenimage
+
+import "fmt"
+
+// AuthError indicates a request was rejected for authentication/authorization
+// reasons, e.g. an upload token that was rejected or expired.
+type AuthError struct {
+	Op  string
+	Err error
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("auth error during %s: %v", e.Op, e.Err)
+}
+
+func (e *AuthError) Unwrap() error { return e.Err }
+
+// NetworkError indicates a failure reaching the apiserver, the upload proxy,
+// or another in-cluster endpoint.
+type NetworkError struct {
+	Op  string
+	Err error
+}
+
+func (e *NetworkError) Error() string {
+	return fmt.Sprintf("network error during %s: %v", e.Op, e.Err)
+}
+
+func (e *NetworkError) Unwrap() error { return e.Err }
+
+// DataVolumePhaseError indicates a DataVolume reached a phase other than the
+// one being waited on.
+type DataVolumePhaseError struct {
+	Name   string
+	Phase  string
+	Wanted string
+}
+
+func (e *DataVolumePhaseError) Error() string {
+	return fmt.Sprintf("DataVolume %s is in phase %q, wanted %q", e.Name, e.Phase, e.Wanted)
+}
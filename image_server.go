@@ -0,0 +1,357 @@
+package goldenimage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// serverPodName, serverSvcName, and serverPort identify the nginx backend's
+// pod and Service; chunked_upload.go targets them directly since chunked
+// transfer is only meaningful against this backend's exec+dd delivery.
+const (
+	serverPodName = "mcs-image-server"
+	serverSvcName = "mcs-image-server"
+	serverPort    = 8080
+)
+
+// ImageServer abstracts the ephemeral, in-cluster endpoint that the UDN
+// HTTP-source workflow pushes a local image through, so CDI can pull it back
+// over HTTP without the uploader needing a direct network path into the
+// namespace. Implementations provision whatever backend they need (a plain
+// nginx pod, a registry, an S3-compatible store), serve the pushed image, and
+// report back the DataVolume source stanza that retrieves it.
+type ImageServer interface {
+	// Prepare provisions the backend (pod/service/security or equivalent) and
+	// must be safe to call once per upload.
+	Prepare(ctx context.Context) error
+
+	// Push streams size bytes from r into the backend and returns the
+	// DataVolume spec.source this backend expects CDI to use.
+	Push(ctx context.Context, r io.Reader, size int64) (map[string]interface{}, error)
+
+	// Cleanup tears down everything Prepare created. Best-effort: errors are
+	// logged, not returned, so a failed cleanup never masks a successful
+	// upload.
+	Cleanup(ctx context.Context)
+}
+
+// nginxServer is the default ImageServer: an unprivileged, network-policy
+// restricted nginx pod serving the pushed image over HTTP Basic Auth, with
+// chunked/resumable delivery when the pushed reader is a local file.
+type nginxServer struct {
+	u        *GoldenImageUploader
+	security *serverSecurity
+	token    serverAuthToken
+
+	podName  string
+	svcName  string
+	confName string
+	port     int32
+}
+
+func newNginxServer(u *GoldenImageUploader) *nginxServer {
+	return &nginxServer{
+		u:        u,
+		security: newServerSecurity(u, serverPodName, serverPort),
+		podName:  serverPodName,
+		svcName:  serverSvcName,
+		confName: serverPodName + "-conf",
+		port:     serverPort,
+	}
+}
+
+// Prepare provisions the ServiceAccount/Secret/NetworkPolicy hardening, the
+// nginx vhost ConfigMap enforcing the generated credential, and the server
+// pod/service themselves, then waits for the pod to become ready.
+func (s *nginxServer) Prepare(ctx context.Context) error {
+	token, err := s.security.provision(ctx)
+	if err != nil {
+		return err
+	}
+	s.token = token
+
+	if err := s.createServerConfigMap(ctx); err != nil {
+		return fmt.Errorf("creating nginx config: %w", err)
+	}
+	if err := s.createServerPod(ctx); err != nil {
+		return fmt.Errorf("creating server pod: %w", err)
+	}
+	if err := s.createServerService(ctx); err != nil {
+		return fmt.Errorf("creating server service: %w", err)
+	}
+	if err := s.waitForPodReady(ctx); err != nil {
+		return fmt.Errorf("waiting for server pod: %w", err)
+	}
+
+	return nil
+}
+
+// Push streams the image into the server pod's web root. When r is a local
+// *os.File it uses the chunked, resumable transfer from chunked_upload.go;
+// otherwise it falls back to a single exec session streaming r directly,
+// since chunking requires the seekable local file, not just byte count.
+func (s *nginxServer) Push(ctx context.Context, r io.Reader, size int64) (map[string]interface{}, error) {
+	if f, ok := r.(*os.File); ok {
+		if err := s.u.StreamImageChunked(ctx, f.Name()); err != nil {
+			return nil, err
+		}
+		return s.sourceFor("disk.qcow2"), nil
+	}
+
+	if err := s.pushNamed(ctx, r, size, "disk.qcow2"); err != nil {
+		return nil, err
+	}
+	return s.sourceFor("disk.qcow2"), nil
+}
+
+// pushNamed streams r into remoteName under the server pod's web root in a
+// single exec session, pre-allocating the file so dd/cat land predictably.
+// Used directly by UploadBatch, where each image in the batch needs its own
+// file served from the one shared pod.
+func (s *nginxServer) pushNamed(ctx context.Context, r io.Reader, size int64, remoteName string) error {
+	remotePath := remoteHTMLPath(remoteName)
+
+	if err := s.execOnPod(ctx, []string{"sh", "-c",
+		fmt.Sprintf("touch %s && truncate -s %d %s", remotePath, size, remotePath)}); err != nil {
+		return fmt.Errorf("allocating remote file: %w", err)
+	}
+	if err := s.streamToPath(ctx, r, remotePath); err != nil {
+		return fmt.Errorf("streaming image: %w", err)
+	}
+	return nil
+}
+
+// sourceFor builds the DataVolume http source stanza for an image already
+// served from remoteName under this server's web root.
+func (s *nginxServer) sourceFor(remoteName string) map[string]interface{} {
+	url := fmt.Sprintf("http://%s.%s.svc.cluster.local:%d/%s", s.svcName, s.u.namespace, s.port, remoteName)
+	return map[string]interface{}{
+		"http": map[string]interface{}{
+			"url":       url,
+			"secretRef": s.security.secretName,
+		},
+	}
+}
+
+// remoteHTMLPath joins a served file name onto the nginx web root.
+func remoteHTMLPath(name string) string {
+	return "/usr/share/nginx/html/" + name
+}
+
+// Cleanup removes the server pod, service, ConfigMap, and the hardening
+// resources provisioned in Prepare.
+func (s *nginxServer) Cleanup(ctx context.Context) {
+	fmt.Println("Cleaning up ephemeral image server...")
+
+	if err := s.u.k8sClient.CoreV1().Services(s.u.namespace).Delete(ctx, s.svcName, metav1.DeleteOptions{}); err != nil {
+		if !k8serrors.IsNotFound(err) {
+			fmt.Printf("Warning: failed to delete service: %v\n", err)
+		}
+	}
+	if err := s.u.k8sClient.CoreV1().Pods(s.u.namespace).Delete(ctx, s.podName, metav1.DeleteOptions{}); err != nil {
+		if !k8serrors.IsNotFound(err) {
+			fmt.Printf("Warning: failed to delete pod: %v\n", err)
+		}
+	}
+	if err := s.u.k8sClient.CoreV1().ConfigMaps(s.u.namespace).Delete(ctx, s.confName, metav1.DeleteOptions{}); err != nil {
+		if !k8serrors.IsNotFound(err) {
+			fmt.Printf("Warning: failed to delete config map: %v\n", err)
+		}
+	}
+
+	s.security.cleanup(ctx)
+}
+
+// createServerConfigMap renders the nginx vhost that serves /usr/share/nginx/html
+// over plain HTTP but rejects any request whose Authorization header doesn't
+// match the generated credential, so only holders of the Secret referenced
+// from the DataVolume's http.secretRef can read the image back.
+func (s *nginxServer) createServerConfigMap(ctx context.Context) error {
+	conf := fmt.Sprintf(`server {
+    listen %d;
+    location / {
+        if ($http_authorization != "%s") {
+            return 401;
+        }
+        root /usr/share/nginx/html;
+        autoindex off;
+    }
+}
+`, s.port, s.token.basicAuthHeader())
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      s.confName,
+			Namespace: s.u.namespace,
+		},
+		Data: map[string]string{"default.conf": conf},
+	}
+
+	_, err := s.u.k8sClient.CoreV1().ConfigMaps(s.u.namespace).Create(ctx, cm, metav1.CreateOptions{})
+	if err != nil && !k8serrors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// createServerPod runs an unprivileged nginx pod hardened per the locked-down
+// defaults: non-root, read-only root filesystem, no privilege escalation,
+// RuntimeDefault seccomp, and all capabilities dropped. The writable
+// directories nginx needs (cache, run, tmp, and the web root it's written to
+// over exec) are all emptyDir volumes.
+func (s *nginxServer) createServerPod(ctx context.Context) error {
+	runAsNonRoot := true
+	runAsUser := int64(101)
+	allowPrivilegeEscalation := false
+	readOnlyRootFilesystem := true
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      s.podName,
+			Namespace: s.u.namespace,
+			Labels:    map[string]string{"app": s.podName},
+		},
+		Spec: corev1.PodSpec{
+			ServiceAccountName: s.security.saName,
+			SecurityContext: &corev1.PodSecurityContext{
+				RunAsNonRoot:   &runAsNonRoot,
+				RunAsUser:      &runAsUser,
+				SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+			},
+			Containers: []corev1.Container{{
+				Name:  "nginx",
+				Image: "nginxinc/nginx-unprivileged:1.25-alpine",
+				Ports: []corev1.ContainerPort{{ContainerPort: s.port}},
+				SecurityContext: &corev1.SecurityContext{
+					AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+					ReadOnlyRootFilesystem:   &readOnlyRootFilesystem,
+					Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+				},
+				VolumeMounts: []corev1.VolumeMount{
+					{Name: "conf", MountPath: "/etc/nginx/conf.d"},
+					{Name: "html", MountPath: "/usr/share/nginx/html"},
+					{Name: "cache", MountPath: "/var/cache/nginx"},
+					{Name: "run", MountPath: "/var/run"},
+					{Name: "tmp", MountPath: "/tmp"},
+				},
+			}},
+			Volumes: []corev1.Volume{
+				{Name: "conf", VolumeSource: corev1.VolumeSource{
+					ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: s.confName}},
+				}},
+				{Name: "html", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+				{Name: "cache", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+				{Name: "run", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+				{Name: "tmp", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+			},
+		},
+	}
+
+	_, err := s.u.k8sClient.CoreV1().Pods(s.u.namespace).Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil && !k8serrors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// createServerService exposes the server pod in-cluster on s.port.
+func (s *nginxServer) createServerService(ctx context.Context) error {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      s.svcName,
+			Namespace: s.u.namespace,
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{"app": s.podName},
+			Ports:    []corev1.ServicePort{{Port: s.port, TargetPort: intstr.FromInt32(s.port)}},
+		},
+	}
+
+	_, err := s.u.k8sClient.CoreV1().Services(s.u.namespace).Create(ctx, svc, metav1.CreateOptions{})
+	if err != nil && !k8serrors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// waitForPodReady polls until the server pod's container is ready to accept
+// exec sessions and HTTP requests.
+func (s *nginxServer) waitForPodReady(ctx context.Context) error {
+	return wait.PollUntilContextTimeout(ctx, 2*time.Second, 2*time.Minute, true,
+		func(ctx context.Context) (bool, error) {
+			pod, err := s.u.k8sClient.CoreV1().Pods(s.u.namespace).Get(ctx, s.podName, metav1.GetOptions{})
+			if err != nil {
+				return false, nil
+			}
+			for _, cond := range pod.Status.Conditions {
+				if cond.Type == corev1.PodReady && cond.Status == corev1.ConditionTrue {
+					return true, nil
+				}
+			}
+			return false, nil
+		})
+}
+
+// streamToPath writes r to remotePath in a single exec session, used when
+// the caller didn't hand us a seekable local file to chunk.
+func (s *nginxServer) streamToPath(ctx context.Context, r io.Reader, remotePath string) error {
+	req := s.u.k8sClient.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Name(s.podName).
+		Namespace(s.u.namespace).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: "nginx",
+			Command:   []string{"sh", "-c", fmt.Sprintf("cat > %s", remotePath)},
+			Stdin:     true,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	exec, err := remotecommand.NewSPDYExecutor(s.u.restConfig, "POST", req.URL())
+	if err != nil {
+		return fmt.Errorf("creating executor: %w", err)
+	}
+
+	return exec.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdin:  r,
+		Stdout: io.Discard,
+		Stderr: os.Stderr,
+	})
+}
+
+// execOnPod runs a command on the server pod with no stdin.
+func (s *nginxServer) execOnPod(ctx context.Context, command []string) error {
+	req := s.u.k8sClient.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Name(s.podName).
+		Namespace(s.u.namespace).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: "nginx",
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	exec, err := remotecommand.NewSPDYExecutor(s.u.restConfig, "POST", req.URL())
+	if err != nil {
+		return fmt.Errorf("creating executor: %w", err)
+	}
+
+	return exec.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdout: io.Discard,
+		Stderr: os.Stderr,
+	})
+}
@@ -0,0 +1,188 @@
+package goldenimage
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// defaultFilesystemOverhead matches CDI's own default filesystem overhead
+// (cdi.kubevirt.io CDIConfig .spec.filesystemOverhead.global), applied when
+// the cluster hasn't overridden it for the target storage class.
+const defaultFilesystemOverhead = 0.055
+
+// cdiConfigGVR is the cluster-scoped CDIConfig resource CDI uses to publish
+// its configured filesystem overhead.
+var cdiConfigGVR = schema.GroupVersionResource{
+	Group:    "cdi.kubevirt.io",
+	Version:  "v1beta1",
+	Resource: "cdiconfigs",
+}
+
+// cdiConfigName is the single, well-known CDIConfig instance CDI maintains.
+const cdiConfigName = "config"
+
+// ImageFormat identifies the disk image container format detected from a
+// local file's header.
+type ImageFormat string
+
+const (
+	ImageFormatQCOW2 ImageFormat = "qcow2"
+	ImageFormatVMDK  ImageFormat = "vmdk"
+	ImageFormatVDI   ImageFormat = "vdi"
+	ImageFormatRaw   ImageFormat = "raw"
+)
+
+// ImageSizeInfo describes how InspectImageSize arrived at a recommended PVC
+// size, so callers can log what was chosen instead of a single opaque
+// string.
+type ImageSizeInfo struct {
+	RecommendedSize string
+	VirtualSize     int64
+	Format          ImageFormat
+	Overhead        float64
+}
+
+const (
+	qcow2MagicOffset = 0
+	qcow2Magic       = 0x514649fb // "QFI\xfb"
+	qcow2SizeOffset  = 24
+
+	vmdkMagic          = 0x564d444b // "KDMV"
+	vmdkCapacityOffset = 12
+
+	vdiSignatureOffset = 0x40
+	vdiSignature       = 0xbeda107f
+	vdiDiskSizeOffset  = 0x170
+)
+
+// InspectImageSize determines the virtual disk size of a local image by
+// parsing its format-specific header (falling back to the file size for
+// unrecognized/raw images), then sizes a PVC to fit it plus filesystem
+// overhead. storageClass, if non-empty, is used to look up a per-class
+// overhead override from the cluster's CDIConfig; dynamicClient may be nil
+// to skip that lookup and use defaultFilesystemOverhead.
+func InspectImageSize(ctx context.Context, dynamicClient dynamic.Interface, imagePath, storageClass string) (*ImageSizeInfo, error) {
+	info, err := os.Stat(imagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	format, virtualSize, err := detectImageFormatAndSize(imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("detecting image format: %w", err)
+	}
+
+	size := virtualSize
+	if info.Size() > size {
+		size = info.Size()
+	}
+
+	overhead := defaultFilesystemOverhead
+	if dynamicClient != nil {
+		if o, err := filesystemOverheadForStorageClass(ctx, dynamicClient, storageClass); err == nil {
+			overhead = o
+		}
+	}
+
+	recommendedBytes := float64(size) * (1 + overhead)
+	recommendedGi := int64(recommendedBytes/(1024*1024*1024)) + 1
+
+	return &ImageSizeInfo{
+		RecommendedSize: fmt.Sprintf("%dGi", recommendedGi),
+		VirtualSize:     size,
+		Format:          format,
+		Overhead:        overhead,
+	}, nil
+}
+
+// detectImageFormatAndSize reads just enough of the local file's header to
+// identify its format and extract the virtual disk size it declares.
+func detectImageFormatAndSize(imagePath string) (ImageFormat, int64, error) {
+	f, err := os.Open(imagePath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	header := make([]byte, 256)
+	n, err := f.Read(header)
+	if err != nil && n == 0 {
+		return "", 0, fmt.Errorf("reading image header: %w", err)
+	}
+	header = header[:n]
+
+	if len(header) >= qcow2SizeOffset+8 && binary.BigEndian.Uint32(header[qcow2MagicOffset:qcow2MagicOffset+4]) == qcow2Magic {
+		size := binary.BigEndian.Uint64(header[qcow2SizeOffset : qcow2SizeOffset+8])
+		return ImageFormatQCOW2, int64(size), nil
+	}
+
+	if len(header) >= vmdkCapacityOffset+8 && binary.LittleEndian.Uint32(header[0:4]) == vmdkMagic {
+		capacitySectors := binary.LittleEndian.Uint64(header[vmdkCapacityOffset : vmdkCapacityOffset+8])
+		return ImageFormatVMDK, int64(capacitySectors) * 512, nil
+	}
+
+	if len(header) >= vdiSignatureOffset+4 && binary.LittleEndian.Uint32(header[vdiSignatureOffset:vdiSignatureOffset+4]) == vdiSignature {
+		vdiHeader := make([]byte, vdiDiskSizeOffset+8)
+		if _, err := f.ReadAt(vdiHeader, 0); err != nil {
+			return "", 0, fmt.Errorf("reading VDI header: %w", err)
+		}
+		size := binary.LittleEndian.Uint64(vdiHeader[vdiDiskSizeOffset : vdiDiskSizeOffset+8])
+		return ImageFormatVDI, int64(size), nil
+	}
+
+	return ImageFormatRaw, 0, nil
+}
+
+// filesystemOverheadForStorageClass queries the cluster's CDIConfig for a
+// per-storage-class filesystem overhead override, falling back to the
+// cluster-wide global overhead, and returning an error only if the CDIConfig
+// itself can't be read (a missing per-class override is not an error).
+func filesystemOverheadForStorageClass(ctx context.Context, dynamicClient dynamic.Interface, storageClass string) (float64, error) {
+	cfg, err := dynamicClient.Resource(cdiConfigGVR).Get(ctx, cdiConfigName, metav1.GetOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("getting CDIConfig: %w", err)
+	}
+
+	status, ok := cfg.Object["status"].(map[string]interface{})
+	if !ok {
+		return 0, fmt.Errorf("CDIConfig status not populated")
+	}
+	overhead, ok := status["filesystemOverhead"].(map[string]interface{})
+	if !ok {
+		return 0, fmt.Errorf("CDIConfig status.filesystemOverhead not populated")
+	}
+
+	if storageClass != "" {
+		if perClass, ok := overhead["storageClass"].(map[string]interface{}); ok {
+			if v, ok := perClass[storageClass].(string); ok {
+				if f, err := parseOverheadFraction(v); err == nil {
+					return f, nil
+				}
+			}
+		}
+	}
+
+	if v, ok := overhead["global"].(string); ok {
+		if f, err := parseOverheadFraction(v); err == nil {
+			return f, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no usable filesystemOverhead in CDIConfig")
+}
+
+// parseOverheadFraction parses CDIConfig's string-encoded overhead fraction
+// (e.g. "0.055") into a float64.
+func parseOverheadFraction(s string) (float64, error) {
+	var f float64
+	if _, err := fmt.Sscanf(s, "%g", &f); err != nil {
+		return 0, fmt.Errorf("parsing overhead %q: %w", s, err)
+	}
+	return f, nil
+}
@@ -0,0 +1,95 @@
+package goldenimage
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestImage writes data to a fresh file under t.TempDir() and returns
+// its path.
+func writeTestImage(t *testing.T, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "disk.img")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("writing test image: %v", err)
+	}
+	return path
+}
+
+func TestDetectImageFormatAndSize(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       func() []byte
+		wantFormat ImageFormat
+		wantSize   int64
+	}{
+		{
+			name: "qcow2",
+			data: func() []byte {
+				header := make([]byte, 64)
+				binary.BigEndian.PutUint32(header[qcow2MagicOffset:], qcow2Magic)
+				binary.BigEndian.PutUint64(header[qcow2SizeOffset:], 10*1024*1024*1024)
+				return header
+			},
+			wantFormat: ImageFormatQCOW2,
+			wantSize:   10 * 1024 * 1024 * 1024,
+		},
+		{
+			name: "vmdk",
+			data: func() []byte {
+				header := make([]byte, 64)
+				binary.LittleEndian.PutUint32(header[0:], vmdkMagic)
+				binary.LittleEndian.PutUint64(header[vmdkCapacityOffset:], 2*1024*1024) // sectors
+				return header
+			},
+			wantFormat: ImageFormatVMDK,
+			wantSize:   2 * 1024 * 1024 * 512,
+		},
+		{
+			name: "vdi",
+			data: func() []byte {
+				header := make([]byte, vdiDiskSizeOffset+8)
+				binary.LittleEndian.PutUint32(header[vdiSignatureOffset:], vdiSignature)
+				binary.LittleEndian.PutUint64(header[vdiDiskSizeOffset:], 5*1024*1024*1024)
+				return header
+			},
+			wantFormat: ImageFormatVDI,
+			wantSize:   5 * 1024 * 1024 * 1024,
+		},
+		{
+			name: "raw falls back to unrecognized format",
+			data: func() []byte {
+				return []byte("not a recognized disk image header")
+			},
+			wantFormat: ImageFormatRaw,
+			wantSize:   0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTestImage(t, tt.data())
+
+			format, size, err := detectImageFormatAndSize(path)
+			if err != nil {
+				t.Fatalf("detectImageFormatAndSize: %v", err)
+			}
+			if format != tt.wantFormat {
+				t.Errorf("format = %q, want %q", format, tt.wantFormat)
+			}
+			if size != tt.wantSize {
+				t.Errorf("size = %d, want %d", size, tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestDetectImageFormatAndSizeEmptyFile(t *testing.T) {
+	path := writeTestImage(t, nil)
+
+	if _, _, err := detectImageFormatAndSize(path); err == nil {
+		t.Error("expected an error reading an empty file's header, got nil")
+	}
+}
@@ -0,0 +1,216 @@
+package goldenimage
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// createUploadDataVolume creates a DataVolume with an upload source, which
+// CDI moves to UploadReady once it has provisioned the PVC and is ready to
+// accept data on the upload proxy.
+func (u *GoldenImageUploader) createUploadDataVolume(ctx context.Context) error {
+	dv := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "cdi.kubevirt.io/v1beta1",
+			"kind":       "DataVolume",
+			"metadata": map[string]interface{}{
+				"name":        u.pvcName,
+				"namespace":   u.namespace,
+				"annotations": annotationsForDataVolume(u.imageChecksum),
+			},
+			"spec": map[string]interface{}{
+				"source": map[string]interface{}{
+					"upload": map[string]interface{}{},
+				},
+				"storage": map[string]interface{}{
+					"resources": map[string]interface{}{
+						"requests": map[string]interface{}{
+							"storage": u.pvcSize,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if u.storageClass != "" {
+		spec := dv.Object["spec"].(map[string]interface{})
+		storage := spec["storage"].(map[string]interface{})
+		storage["storageClassName"] = u.storageClass
+	}
+
+	_, err := u.dynamicClient.Resource(dataVolumeGVR).Namespace(u.namespace).Create(ctx, dv, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("creating upload DataVolume: %w", err)
+	}
+	return nil
+}
+
+// createUploadTokenRequest creates an UploadTokenRequest for the target PVC
+// and waits for CDI to populate its status with a short-lived upload token.
+func (u *GoldenImageUploader) createUploadTokenRequest(ctx context.Context) (string, error) {
+	utr := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "cdi.kubevirt.io/v1beta1",
+			"kind":       "UploadTokenRequest",
+			"metadata": map[string]interface{}{
+				"name":      u.pvcName,
+				"namespace": u.namespace,
+			},
+			"spec": map[string]interface{}{
+				"pvcName": u.pvcName,
+			},
+		},
+	}
+
+	if _, err := u.dynamicClient.Resource(uploadTokenRequestGVR).Namespace(u.namespace).Create(ctx, utr, metav1.CreateOptions{}); err != nil {
+		return "", &AuthError{Op: "creating UploadTokenRequest", Err: err}
+	}
+
+	var token string
+	err := wait.PollUntilContextTimeout(ctx, 2*time.Second, 60*time.Second, true,
+		func(ctx context.Context) (bool, error) {
+			obj, err := u.dynamicClient.Resource(uploadTokenRequestGVR).Namespace(u.namespace).Get(ctx, u.pvcName, metav1.GetOptions{})
+			if err != nil {
+				return false, fmt.Errorf("getting UploadTokenRequest: %w", err)
+			}
+
+			status, ok := obj.Object["status"].(map[string]interface{})
+			if !ok {
+				return false, nil
+			}
+			t, ok := status["token"].(string)
+			if !ok || t == "" {
+				return false, nil
+			}
+			token = t
+			return true, nil
+		})
+	if err != nil {
+		return "", &AuthError{Op: "waiting for upload token", Err: err}
+	}
+	return token, nil
+}
+
+// discoverUploadProxyURL returns the base URL of the CDI upload proxy,
+// honoring an explicit --upload-proxy-url override before falling back to
+// discovering the cdi-uploadproxy Service in the cdi namespace.
+func (u *GoldenImageUploader) discoverUploadProxyURL(ctx context.Context) (string, error) {
+	if u.uploadProxyURL != "" {
+		return u.uploadProxyURL, nil
+	}
+
+	svc, err := u.k8sClient.CoreV1().Services(defaultUploadProxyNamespace).Get(ctx, defaultUploadProxyService, metav1.GetOptions{})
+	if err != nil {
+		return "", &NetworkError{Op: "discovering upload proxy service", Err: err}
+	}
+
+	port := int32(443)
+	if len(svc.Spec.Ports) > 0 {
+		port = svc.Spec.Ports[0].Port
+	}
+	return fmt.Sprintf("https://%s.%s.svc.cluster.local:%d", svc.Name, svc.Namespace, port), nil
+}
+
+// postImageToUploadProxy streams the local image to the CDI upload proxy's
+// async upload endpoint, authenticating with the short-lived upload token.
+func (u *GoldenImageUploader) postImageToUploadProxy(ctx context.Context, proxyURL, token string, body io.Reader, size int64) error {
+	tlsConfig := &tls.Config{}
+	if u.insecureTLS {
+		tlsConfig.InsecureSkipVerify = true
+	} else {
+		caPool, err := u.uploadProxyCACertPool(ctx)
+		if err != nil {
+			return &NetworkError{Op: "loading upload proxy CA bundle", Err: err}
+		}
+		tlsConfig.RootCAs = caPool
+	}
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+
+	progress := &progressReader{r: body, total: size}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, proxyURL+"/v1beta1/upload-async", progress)
+	if err != nil {
+		return fmt.Errorf("building upload request: %w", err)
+	}
+	req.ContentLength = size
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return &NetworkError{Op: "uploading image to proxy", Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return &AuthError{Op: "uploading image", Err: fmt.Errorf("upload proxy returned %s", resp.Status)}
+	}
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("upload proxy returned %s: %s", resp.Status, string(respBody))
+	}
+
+	fmt.Println("Upload accepted by proxy, CDI will finish importing asynchronously.")
+	return nil
+}
+
+// uploadProxyServerCertSecret is the Secret the service-ca operator
+// populates for the cdi-uploadproxy Service's serving certificate, per that
+// Service's service.beta.openshift.io/serving-cert-secret-name annotation.
+const uploadProxyServerCertSecret = "cdi-uploadproxy-server-cert"
+
+// uploadProxyCACertPool builds a cert pool trusting the upload proxy's own
+// serving certificate. The proxy's cert is signed by the cluster's
+// service-serving CA, not the kube-apiserver CA carried on restConfig, so
+// restConfig's CA bundle never verifies it; pinning directly to the
+// certificate the service-ca operator publishes alongside the proxy Service
+// avoids needing a separate handle on that CA.
+func (u *GoldenImageUploader) uploadProxyCACertPool(ctx context.Context) (*x509.CertPool, error) {
+	secret, err := u.k8sClient.CoreV1().Secrets(defaultUploadProxyNamespace).Get(ctx, uploadProxyServerCertSecret, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("getting upload proxy serving cert secret: %w", err)
+	}
+
+	certPEM := secret.Data["tls.crt"]
+	if len(certPEM) == 0 {
+		return nil, fmt.Errorf("secret %s/%s has no tls.crt", defaultUploadProxyNamespace, uploadProxyServerCertSecret)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(certPEM) {
+		return nil, fmt.Errorf("parsing upload proxy serving certificate")
+	}
+	return pool, nil
+}
+
+// progressReader wraps an io.Reader and periodically reports upload
+// progress as bytes flow through it.
+type progressReader struct {
+	r         io.Reader
+	total     int64
+	read      int64
+	lastPrint time.Time
+}
+
+func (p *progressReader) Read(buf []byte) (int, error) {
+	n, err := p.r.Read(buf)
+	p.read += int64(n)
+
+	if time.Since(p.lastPrint) > 2*time.Second || err == io.EOF {
+		pct := float64(p.read) / float64(p.total) * 100
+		fmt.Printf("Uploading... %.1f%% (%d/%d bytes)\n", pct, p.read, p.total)
+		p.lastPrint = time.Now()
+	}
+	return n, err
+}
@@ -0,0 +1,434 @@
+package goldenimage
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+)
+
+// ociRegistryServer is an ImageServer backed by an ephemeral registry:2 pod.
+// The local qcow2 is pushed as the single layer of an OCI artifact, and CDI's
+// registry importer pulls it back by digest, getting native resumable/range
+// support from the registry instead of relying on this tool's own chunking.
+//
+// Unlike the nginx backend, Push talks to the registry Service directly over
+// HTTP instead of through an exec session into the pod, so it needs in-cluster
+// network reachability to the pod: in the Primary-UDN namespaces this tool
+// targets, where that reachability is exactly what's isolated away, this
+// backend only works when the process running this tool itself has a network
+// path into the namespace (e.g. it's running in-cluster), not from a
+// workstation outside the cluster. Because Push's own caller needs to reach
+// the registry and isn't a CDI importer pod, its NetworkPolicy admits any pod
+// in the namespace rather than the importer-only rule nginx uses.
+type ociRegistryServer struct {
+	u        *GoldenImageUploader
+	security *serverSecurity
+
+	podName   string
+	svcName   string
+	port      int32
+	repo      string
+	digest    string
+	configOID string
+}
+
+func newOCIRegistryServer(u *GoldenImageUploader) *ociRegistryServer {
+	const name = "mcs-image-registry"
+	return &ociRegistryServer{
+		u:        u,
+		security: newServerSecurityWithIngress(u, name, 5000, false),
+		podName:  name,
+		svcName:  name,
+		port:     5000,
+		repo:     "golden-images/" + u.pvcName,
+	}
+}
+
+// Prepare provisions the hardening resources and the registry pod/service,
+// waits for the registry's /v2/ endpoint to respond, then registers the
+// registry's in-cluster host as an insecure (plain HTTP) registry in the
+// cluster's CDIConfig, since CDI's registry importer otherwise assumes TLS.
+func (s *ociRegistryServer) Prepare(ctx context.Context) error {
+	if _, err := s.security.provision(ctx); err != nil {
+		return err
+	}
+	if err := s.createRegistryPod(ctx); err != nil {
+		return fmt.Errorf("creating registry pod: %w", err)
+	}
+	if err := s.createRegistryService(ctx); err != nil {
+		return fmt.Errorf("creating registry service: %w", err)
+	}
+	if err := s.waitForRegistryReady(ctx); err != nil {
+		return err
+	}
+	if err := s.addInsecureRegistryHost(ctx); err != nil {
+		return fmt.Errorf("registering insecure registry: %w", err)
+	}
+	return nil
+}
+
+// Push uploads r, wrapped in a tar archive under disk/disk.qcow2, as a
+// single blob via the distribution-spec chunked upload endpoints, pushes a
+// minimal config blob, and puts the manifest tying them together, returning
+// a registry source CDI can pull from directly.
+func (s *ociRegistryServer) Push(ctx context.Context, r io.Reader, size int64) (map[string]interface{}, error) {
+	baseURL := fmt.Sprintf("http://%s.%s.svc.cluster.local:%d", s.svcName, s.u.namespace, s.port)
+
+	layerSize := tarLayerSize(size)
+	digest, err := s.uploadBlob(ctx, baseURL, tarWrapReader(r, size), layerSize)
+	if err != nil {
+		return nil, fmt.Errorf("uploading image layer: %w", err)
+	}
+	s.digest = digest
+
+	configDigest, err := s.uploadBlob(ctx, baseURL, bytes.NewReader([]byte("{}")), 2)
+	if err != nil {
+		return nil, fmt.Errorf("uploading config blob: %w", err)
+	}
+	s.configOID = configDigest
+
+	if err := s.putManifest(ctx, baseURL, configDigest, digest, layerSize); err != nil {
+		return nil, fmt.Errorf("pushing manifest: %w", err)
+	}
+
+	url := fmt.Sprintf("docker://%s/%s:latest", s.registryHost(), s.repo)
+	return map[string]interface{}{
+		"registry": map[string]interface{}{
+			"url": url,
+		},
+	}, nil
+}
+
+// Cleanup removes the registry pod/service, the hardening resources, and the
+// insecure-registry entry added to the cluster's CDIConfig in Prepare.
+func (s *ociRegistryServer) Cleanup(ctx context.Context) {
+	fmt.Println("Cleaning up ephemeral registry server...")
+
+	if err := s.removeInsecureRegistryHost(ctx); err != nil {
+		fmt.Printf("Warning: failed to remove insecure registry entry: %v\n", err)
+	}
+
+	if err := s.u.k8sClient.CoreV1().Services(s.u.namespace).Delete(ctx, s.svcName, metav1.DeleteOptions{}); err != nil {
+		if !k8serrors.IsNotFound(err) {
+			fmt.Printf("Warning: failed to delete service: %v\n", err)
+		}
+	}
+	if err := s.u.k8sClient.CoreV1().Pods(s.u.namespace).Delete(ctx, s.podName, metav1.DeleteOptions{}); err != nil {
+		if !k8serrors.IsNotFound(err) {
+			fmt.Printf("Warning: failed to delete pod: %v\n", err)
+		}
+	}
+
+	s.security.cleanup(ctx)
+}
+
+// uploadBlob runs the three-step distribution-spec upload (start, PATCH
+// chunk, PUT with digest) for a single blob and returns its digest.
+func (s *ociRegistryServer) uploadBlob(ctx context.Context, baseURL string, r io.Reader, size int64) (string, error) {
+	startReq, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		fmt.Sprintf("%s/v2/%s/blobs/uploads/", baseURL, s.repo), nil)
+	if err != nil {
+		return "", err
+	}
+	startResp, err := http.DefaultClient.Do(startReq)
+	if err != nil {
+		return "", &NetworkError{Op: "starting blob upload", Err: err}
+	}
+	defer startResp.Body.Close()
+	if startResp.StatusCode != http.StatusAccepted {
+		return "", fmt.Errorf("starting blob upload: unexpected status %s", startResp.Status)
+	}
+	uploadURL := startResp.Header.Get("Location")
+
+	hasher := sha256.New()
+	tee := io.TeeReader(r, hasher)
+
+	patchReq, err := http.NewRequestWithContext(ctx, http.MethodPatch, uploadURL, tee)
+	if err != nil {
+		return "", err
+	}
+	patchReq.ContentLength = size
+	patchReq.Header.Set("Content-Type", "application/octet-stream")
+	patchResp, err := http.DefaultClient.Do(patchReq)
+	if err != nil {
+		return "", &NetworkError{Op: "uploading blob chunk", Err: err}
+	}
+	defer patchResp.Body.Close()
+	if patchResp.StatusCode != http.StatusAccepted {
+		return "", fmt.Errorf("uploading blob chunk: unexpected status %s", patchResp.Status)
+	}
+	uploadURL = patchResp.Header.Get("Location")
+
+	digest := "sha256:" + hex.EncodeToString(hasher.Sum(nil))
+
+	putReq, err := http.NewRequestWithContext(ctx, http.MethodPut, uploadURL+"&digest="+digest, nil)
+	if err != nil {
+		return "", err
+	}
+	putResp, err := http.DefaultClient.Do(putReq)
+	if err != nil {
+		return "", &NetworkError{Op: "completing blob upload", Err: err}
+	}
+	defer putResp.Body.Close()
+	if putResp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("completing blob upload: unexpected status %s", putResp.Status)
+	}
+
+	return digest, nil
+}
+
+// putManifest pushes an OCI image manifest referencing the uploaded config
+// and layer blobs, tagged "latest" so the registry source URL is stable.
+func (s *ociRegistryServer) putManifest(ctx context.Context, baseURL, configDigest, layerDigest string, layerSize int64) error {
+	manifest := map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.oci.image.manifest.v1+json",
+		"config": map[string]interface{}{
+			"mediaType": "application/vnd.oci.image.config.v1+json",
+			"digest":    configDigest,
+			"size":      2,
+		},
+		"layers": []map[string]interface{}{{
+			"mediaType": "application/vnd.oci.image.layer.v1.tar",
+			"digest":    layerDigest,
+			"size":      layerSize,
+		}},
+	}
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut,
+		fmt.Sprintf("%s/v2/%s/manifests/latest", baseURL, s.repo), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return &NetworkError{Op: "pushing manifest", Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("pushing manifest: unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// tarDiskEntryName is the path CDI's registry importer looks under to find
+// the disk image inside an extracted layer.
+const tarDiskEntryName = "disk/disk.qcow2"
+
+// tarLayerSize returns the size of the tar archive tarWrapReader produces
+// for a contentSize-byte file: a single 512-byte ustar header, the content
+// padded up to the next 512-byte boundary, and the two 512-byte zero blocks
+// marking the end of the archive.
+func tarLayerSize(contentSize int64) int64 {
+	paddedContent := ((contentSize + 511) / 512) * 512
+	return 512 + paddedContent + 1024
+}
+
+// tarWrapReader streams r as the sole entry of a tar archive named
+// tarDiskEntryName, so the pushed layer is something CDI's registry importer
+// (which extracts tar layers and looks for a disk file under /disk) can
+// actually find, rather than a bare qcow2 blob.
+func tarWrapReader(r io.Reader, size int64) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(pw)
+		hdr := &tar.Header{
+			Name:     tarDiskEntryName,
+			Mode:     0o644,
+			Size:     size,
+			Typeflag: tar.TypeReg,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			pw.CloseWithError(fmt.Errorf("writing tar header: %w", err))
+			return
+		}
+		if _, err := io.Copy(tw, r); err != nil {
+			pw.CloseWithError(fmt.Errorf("writing tar content: %w", err))
+			return
+		}
+		if err := tw.Close(); err != nil {
+			pw.CloseWithError(fmt.Errorf("closing tar archive: %w", err))
+			return
+		}
+		pw.Close()
+	}()
+	return pr
+}
+
+// createRegistryPod runs the stock registry:2 image; it needs a writable
+// /var/lib/registry for blob storage, provided as an emptyDir since the data
+// only needs to outlive a single upload.
+func (s *ociRegistryServer) createRegistryPod(ctx context.Context) error {
+	runAsNonRoot := true
+	runAsUser := int64(1000)
+	allowPrivilegeEscalation := false
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      s.podName,
+			Namespace: s.u.namespace,
+			Labels:    map[string]string{"app": s.podName},
+		},
+		Spec: corev1.PodSpec{
+			ServiceAccountName: s.security.saName,
+			SecurityContext: &corev1.PodSecurityContext{
+				RunAsNonRoot:   &runAsNonRoot,
+				RunAsUser:      &runAsUser,
+				SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+			},
+			Containers: []corev1.Container{{
+				Name:  "registry",
+				Image: "registry:2",
+				Ports: []corev1.ContainerPort{{ContainerPort: s.port}},
+				SecurityContext: &corev1.SecurityContext{
+					AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+					Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+				},
+				VolumeMounts: []corev1.VolumeMount{
+					{Name: "data", MountPath: "/var/lib/registry"},
+				},
+			}},
+			Volumes: []corev1.Volume{
+				{Name: "data", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+			},
+		},
+	}
+
+	_, err := s.u.k8sClient.CoreV1().Pods(s.u.namespace).Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil && !k8serrors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *ociRegistryServer) createRegistryService(ctx context.Context) error {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      s.svcName,
+			Namespace: s.u.namespace,
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{"app": s.podName},
+			Ports:    []corev1.ServicePort{{Port: s.port, TargetPort: intstr.FromInt32(s.port)}},
+		},
+	}
+
+	_, err := s.u.k8sClient.CoreV1().Services(s.u.namespace).Create(ctx, svc, metav1.CreateOptions{})
+	if err != nil && !k8serrors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// registryHost is the in-cluster host:port CDI's registry importer must
+// treat as insecure, since the registry pod serves plain HTTP, not TLS.
+func (s *ociRegistryServer) registryHost() string {
+	return fmt.Sprintf("%s.%s.svc.cluster.local:%d", s.svcName, s.u.namespace, s.port)
+}
+
+// addInsecureRegistryHost adds registryHost to the cluster's
+// CDIConfig.spec.insecureRegistries, the cluster-wide mechanism CDI's
+// registry importer checks before deciding whether to require TLS.
+func (s *ociRegistryServer) addInsecureRegistryHost(ctx context.Context) error {
+	host := s.registryHost()
+	return s.patchInsecureRegistries(ctx, func(hosts []string) []string {
+		for _, h := range hosts {
+			if h == host {
+				return hosts
+			}
+		}
+		return append(hosts, host)
+	})
+}
+
+// removeInsecureRegistryHost undoes addInsecureRegistryHost during Cleanup.
+func (s *ociRegistryServer) removeInsecureRegistryHost(ctx context.Context) error {
+	host := s.registryHost()
+	return s.patchInsecureRegistries(ctx, func(hosts []string) []string {
+		kept := hosts[:0]
+		for _, h := range hosts {
+			if h != host {
+				kept = append(kept, h)
+			}
+		}
+		return kept
+	})
+}
+
+// patchInsecureRegistries reads the cluster's CDIConfig, applies mutate to
+// its spec.insecureRegistries list, and writes it back, retrying on a
+// conflicting concurrent update since CDIConfig is a single cluster-wide
+// resource that a parallel batch upload (or another instance of this tool)
+// may also be patching.
+func (s *ociRegistryServer) patchInsecureRegistries(ctx context.Context, mutate func([]string) []string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cfg, err := s.u.dynamicClient.Resource(cdiConfigGVR).Get(ctx, cdiConfigName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("getting CDIConfig: %w", err)
+		}
+
+		spec, ok := cfg.Object["spec"].(map[string]interface{})
+		if !ok {
+			spec = map[string]interface{}{}
+			cfg.Object["spec"] = spec
+		}
+
+		var hosts []string
+		if raw, ok := spec["insecureRegistries"].([]interface{}); ok {
+			for _, v := range raw {
+				if h, ok := v.(string); ok {
+					hosts = append(hosts, h)
+				}
+			}
+		}
+
+		hosts = mutate(hosts)
+
+		rawHosts := make([]interface{}, len(hosts))
+		for i, h := range hosts {
+			rawHosts[i] = h
+		}
+		spec["insecureRegistries"] = rawHosts
+
+		_, err = s.u.dynamicClient.Resource(cdiConfigGVR).Update(ctx, cfg, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func (s *ociRegistryServer) waitForRegistryReady(ctx context.Context) error {
+	url := fmt.Sprintf("http://%s.%s.svc.cluster.local:%d/v2/", s.svcName, s.u.namespace, s.port)
+
+	return wait.PollUntilContextTimeout(ctx, 2*time.Second, 2*time.Minute, true,
+		func(ctx context.Context) (bool, error) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+			if err != nil {
+				return false, nil
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return false, nil
+			}
+			defer resp.Body.Close()
+			return resp.StatusCode == http.StatusOK, nil
+		})
+}
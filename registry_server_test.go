@@ -0,0 +1,40 @@
+package goldenimage
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// TestTarLayerSize checks tarLayerSize's predicted size against the actual
+// number of bytes tarWrapReader produces for the same content, since the two
+// have to stay in lockstep: Push reports tarLayerSize to the registry before
+// it has actually read tarWrapReader's output.
+func TestTarLayerSize(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentSize int64
+	}{
+		{"empty content", 0},
+		{"smaller than one block", 1},
+		{"exactly one block", 512},
+		{"one byte past a block boundary", 513},
+		{"several blocks, unaligned", 100_000 + 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := bytes.Repeat([]byte{0x42}, int(tt.contentSize))
+
+			wrapped, err := io.ReadAll(tarWrapReader(bytes.NewReader(content), tt.contentSize))
+			if err != nil {
+				t.Fatalf("reading tarWrapReader output: %v", err)
+			}
+
+			want := tarLayerSize(tt.contentSize)
+			if got := int64(len(wrapped)); got != want {
+				t.Errorf("tar archive is %d bytes, tarLayerSize predicted %d", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,386 @@
+package goldenimage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// s3Server is an ImageServer backed by an ephemeral single-node MinIO pod.
+// The local qcow2 is uploaded via the S3 multipart API, which gives large
+// images native resumable upload semantics without this tool having to
+// reimplement its own chunk bookkeeping, and CDI's S3 importer pulls it back
+// using the same generated credential.
+//
+// Unlike the nginx backend, Push talks to the MinIO Service directly over
+// HTTP instead of through an exec session into the pod, so it needs in-cluster
+// network reachability to the pod: in the Primary-UDN namespaces this tool
+// targets, where that reachability is exactly what's isolated away, this
+// backend only works when the process running this tool itself has a network
+// path into the namespace (e.g. it's running in-cluster), not from a
+// workstation outside the cluster. Because Push's own caller needs to reach
+// MinIO and isn't a CDI importer pod, its NetworkPolicy admits any pod in the
+// namespace rather than the importer-only rule nginx uses.
+type s3Server struct {
+	u        *GoldenImageUploader
+	security *serverSecurity
+	creds    sigv4Credentials
+
+	podName    string
+	svcName    string
+	port       int32
+	bucket     string
+	objectKey  string
+	secretName string
+}
+
+const s3PartSize = 64 * 1024 * 1024 // 64 MiB, matching the default chunk size elsewhere
+
+func newS3Server(u *GoldenImageUploader) *s3Server {
+	const name = "mcs-image-s3"
+	return &s3Server{
+		u:          u,
+		security:   newServerSecurityWithIngress(u, name, 9000, false),
+		podName:    name,
+		svcName:    name,
+		port:       9000,
+		bucket:     "golden-images",
+		objectKey:  u.pvcName + ".qcow2",
+		secretName: name + "-s3-creds",
+	}
+}
+
+// Prepare provisions the hardening resources, generates a root credential
+// for the MinIO instance, runs its pod/service, and waits for it to answer
+// health checks before creating the bucket the image will land in.
+func (s *s3Server) Prepare(ctx context.Context) error {
+	if _, err := s.security.provision(ctx); err != nil {
+		return err
+	}
+
+	accessKey, secretKey, err := s.generateCredential(ctx)
+	if err != nil {
+		return err
+	}
+	s.creds = sigv4Credentials{AccessKeyID: accessKey, SecretAccessKey: secretKey, Region: "us-east-1", Service: "s3"}
+
+	if err := s.createMinioPod(ctx, accessKey, secretKey); err != nil {
+		return fmt.Errorf("creating S3 pod: %w", err)
+	}
+	if err := s.createMinioService(ctx); err != nil {
+		return fmt.Errorf("creating S3 service: %w", err)
+	}
+	if err := s.waitForMinioReady(ctx); err != nil {
+		return fmt.Errorf("waiting for S3 server: %w", err)
+	}
+	return s.createBucket(ctx)
+}
+
+// Push uploads r to MinIO using CreateMultipartUpload/UploadPart/
+// CompleteMultipartUpload, splitting into s3PartSize parts so a single part
+// failure doesn't require restarting the whole transfer.
+func (s *s3Server) Push(ctx context.Context, r io.Reader, size int64) (map[string]interface{}, error) {
+	baseURL := fmt.Sprintf("http://%s.%s.svc.cluster.local:%d", s.svcName, s.u.namespace, s.port)
+
+	uploadID, err := s.createMultipartUpload(ctx, baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("starting multipart upload: %w", err)
+	}
+
+	var parts []completedPart
+	buf := make([]byte, s3PartSize)
+	for partNum := 1; ; partNum++ {
+		n, readErr := io.ReadFull(r, buf)
+		if n > 0 {
+			etag, err := s.uploadPart(ctx, baseURL, uploadID, partNum, buf[:n])
+			if err != nil {
+				return nil, fmt.Errorf("uploading part %d: %w", partNum, err)
+			}
+			parts = append(parts, completedPart{PartNumber: partNum, ETag: etag})
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return nil, fmt.Errorf("reading part %d: %w", partNum, readErr)
+		}
+	}
+
+	if err := s.completeMultipartUpload(ctx, baseURL, uploadID, parts); err != nil {
+		return nil, fmt.Errorf("completing multipart upload: %w", err)
+	}
+
+	// CDI's S3 importer resolves url against the endpoint host it's given
+	// rather than assuming AWS, so it must point at this ephemeral MinIO
+	// Service, not a bucket-only s3:// URL.
+	url := fmt.Sprintf("http://%s.%s.svc.cluster.local:%d/%s/%s", s.svcName, s.u.namespace, s.port, s.bucket, s.objectKey)
+	return map[string]interface{}{
+		"s3": map[string]interface{}{
+			"url":       url,
+			"secretRef": s.secretName,
+		},
+	}, nil
+}
+
+// Cleanup removes the MinIO pod/service, the generated credential Secret,
+// and the hardening resources.
+func (s *s3Server) Cleanup(ctx context.Context) {
+	fmt.Println("Cleaning up ephemeral S3 server...")
+
+	if err := s.u.k8sClient.CoreV1().Services(s.u.namespace).Delete(ctx, s.svcName, metav1.DeleteOptions{}); err != nil {
+		if !k8serrors.IsNotFound(err) {
+			fmt.Printf("Warning: failed to delete service: %v\n", err)
+		}
+	}
+	if err := s.u.k8sClient.CoreV1().Pods(s.u.namespace).Delete(ctx, s.podName, metav1.DeleteOptions{}); err != nil {
+		if !k8serrors.IsNotFound(err) {
+			fmt.Printf("Warning: failed to delete pod: %v\n", err)
+		}
+	}
+	if err := s.u.k8sClient.CoreV1().Secrets(s.u.namespace).Delete(ctx, s.secretName, metav1.DeleteOptions{}); err != nil {
+		if !k8serrors.IsNotFound(err) {
+			fmt.Printf("Warning: failed to delete S3 credential secret: %v\n", err)
+		}
+	}
+
+	s.security.cleanup(ctx)
+}
+
+// generateCredential creates a root access key/secret key for the MinIO
+// instance and stores it in a Secret shaped the way CDI's S3 secretRef
+// expects (accessKeyId/secretKey), reusing the same Secret for the server's
+// own MINIO_ROOT_USER/MINIO_ROOT_PASSWORD.
+func (s *s3Server) generateCredential(ctx context.Context) (accessKey, secretKey string, err error) {
+	rawAccess := make([]byte, 10)
+	rawSecret := make([]byte, 20)
+	if _, err := rand.Read(rawAccess); err != nil {
+		return "", "", fmt.Errorf("generating access key: %w", err)
+	}
+	if _, err := rand.Read(rawSecret); err != nil {
+		return "", "", fmt.Errorf("generating secret key: %w", err)
+	}
+	accessKey = hex.EncodeToString(rawAccess)
+	secretKey = hex.EncodeToString(rawSecret)
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      s.secretName,
+			Namespace: s.u.namespace,
+		},
+		Type: corev1.SecretTypeOpaque,
+		StringData: map[string]string{
+			"accessKeyId": accessKey,
+			"secretKey":   secretKey,
+		},
+	}
+	if _, err := s.u.k8sClient.CoreV1().Secrets(s.u.namespace).Create(ctx, secret, metav1.CreateOptions{}); err != nil && !k8serrors.IsAlreadyExists(err) {
+		return "", "", fmt.Errorf("creating S3 credential secret: %w", err)
+	}
+
+	return accessKey, secretKey, nil
+}
+
+func (s *s3Server) createMinioPod(ctx context.Context, accessKey, secretKey string) error {
+	runAsNonRoot := true
+	runAsUser := int64(1000)
+	allowPrivilegeEscalation := false
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      s.podName,
+			Namespace: s.u.namespace,
+			Labels:    map[string]string{"app": s.podName},
+		},
+		Spec: corev1.PodSpec{
+			ServiceAccountName: s.security.saName,
+			SecurityContext: &corev1.PodSecurityContext{
+				RunAsNonRoot:   &runAsNonRoot,
+				RunAsUser:      &runAsUser,
+				SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+			},
+			Containers: []corev1.Container{{
+				Name:    "minio",
+				Image:   "minio/minio:latest",
+				Command: []string{"minio", "server", "/data"},
+				Ports:   []corev1.ContainerPort{{ContainerPort: s.port}},
+				Env: []corev1.EnvVar{
+					{Name: "MINIO_ROOT_USER", Value: accessKey},
+					{Name: "MINIO_ROOT_PASSWORD", Value: secretKey},
+				},
+				SecurityContext: &corev1.SecurityContext{
+					AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+					Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+				},
+				VolumeMounts: []corev1.VolumeMount{
+					{Name: "data", MountPath: "/data"},
+				},
+			}},
+			Volumes: []corev1.Volume{
+				{Name: "data", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+			},
+		},
+	}
+
+	_, err := s.u.k8sClient.CoreV1().Pods(s.u.namespace).Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil && !k8serrors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *s3Server) createMinioService(ctx context.Context) error {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      s.svcName,
+			Namespace: s.u.namespace,
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{"app": s.podName},
+			Ports:    []corev1.ServicePort{{Port: s.port, TargetPort: intstr.FromInt32(s.port)}},
+		},
+	}
+
+	_, err := s.u.k8sClient.CoreV1().Services(s.u.namespace).Create(ctx, svc, metav1.CreateOptions{})
+	if err != nil && !k8serrors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *s3Server) waitForMinioReady(ctx context.Context) error {
+	url := fmt.Sprintf("http://%s.%s.svc.cluster.local:%d/minio/health/live", s.svcName, s.u.namespace, s.port)
+
+	return wait.PollUntilContextTimeout(ctx, 2*time.Second, 2*time.Minute, true,
+		func(ctx context.Context) (bool, error) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+			if err != nil {
+				return false, nil
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return false, nil
+			}
+			defer resp.Body.Close()
+			return resp.StatusCode == http.StatusOK, nil
+		})
+}
+
+// createBucket issues a signed PUT to create the destination bucket if it
+// doesn't already exist.
+func (s *s3Server) createBucket(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, fmt.Sprintf("http://%s.%s.svc.cluster.local:%d/%s",
+		s.svcName, s.u.namespace, s.port, s.bucket), nil)
+	if err != nil {
+		return err
+	}
+	signSigV4(req, nil, s.creds)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return &NetworkError{Op: "creating S3 bucket", Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusConflict {
+		return fmt.Errorf("creating S3 bucket: unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *s3Server) createMultipartUpload(ctx context.Context, baseURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		fmt.Sprintf("%s/%s/%s?uploads=", baseURL, s.bucket, s.objectKey), nil)
+	if err != nil {
+		return "", err
+	}
+	signSigV4(req, nil, s.creds)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", &NetworkError{Op: "creating multipart upload", Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("creating multipart upload: unexpected status %s", resp.Status)
+	}
+
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("decoding CreateMultipartUpload response: %w", err)
+	}
+	return result.UploadID, nil
+}
+
+func (s *s3Server) uploadPart(ctx context.Context, baseURL, uploadID string, partNum int, data []byte) (string, error) {
+	url := fmt.Sprintf("%s/%s/%s?partNumber=%d&uploadId=%s", baseURL, s.bucket, s.objectKey, partNum, uploadID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = int64(len(data))
+	signSigV4(req, data, s.creds)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", &NetworkError{Op: "uploading S3 part", Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("uploading S3 part: unexpected status %s", resp.Status)
+	}
+	return resp.Header.Get("ETag"), nil
+}
+
+type completedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+func (s *s3Server) completeMultipartUpload(ctx context.Context, baseURL, uploadID string, parts []completedPart) error {
+	type part struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+	body := struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{}
+	for _, p := range parts {
+		body.Parts = append(body.Parts, part{PartNumber: p.PartNumber, ETag: p.ETag})
+	}
+	payload, err := xml.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/%s/%s?uploadId=%s", baseURL, s.bucket, s.objectKey, uploadID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(payload))
+	signSigV4(req, payload, s.creds)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return &NetworkError{Op: "completing S3 multipart upload", Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("completing S3 multipart upload: unexpected status %s", resp.Status)
+	}
+	return nil
+}
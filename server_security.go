@@ -0,0 +1,233 @@
+package goldenimage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// Labels identifying a CDI importer pod allowed to reach an ephemeral image
+// server, and the fixed username paired with a freshly generated secretKey
+// for each upload.
+const (
+	cdiImporterLabelKey   = "cdi.kubevirt.io"
+	cdiImporterLabelValue = "cdi-importer"
+
+	accessKeyID = "cdi-importer"
+)
+
+// serverAuthToken holds the generated credential used to authenticate the
+// CDI importer against an ephemeral image server, so it can be reused by
+// both the server's own auth config and the Secret referenced from the
+// DataVolume source.
+type serverAuthToken struct {
+	accessKeyID string
+	secretKey   string
+}
+
+// basicAuthHeader returns the "Basic <base64>" value an HTTP-based server
+// should expect on the Authorization header.
+func (t serverAuthToken) basicAuthHeader() string {
+	creds := base64.StdEncoding.EncodeToString([]byte(t.accessKeyID + ":" + t.secretKey))
+	return "Basic " + creds
+}
+
+// generateServerAuthToken creates a fresh, short-lived credential for an
+// ephemeral image server.
+func generateServerAuthToken() (serverAuthToken, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return serverAuthToken{}, fmt.Errorf("generating auth token: %w", err)
+	}
+	return serverAuthToken{accessKeyID: accessKeyID, secretKey: hex.EncodeToString(raw)}, nil
+}
+
+// serverSecurity names the ServiceAccount, auth Secret, and NetworkPolicy
+// provisioned for a given ephemeral server pod (identified by its "app"
+// label and port), so every ImageServer implementation can be locked down
+// the same way.
+type serverSecurity struct {
+	u            *GoldenImageUploader
+	podLabel     string
+	port         int32
+	importerOnly bool
+	saName       string
+	secretName   string
+	policyName   string
+}
+
+// newServerSecurity configures hardening for a server pod reached only by
+// the CDI importer, e.g. the nginx backend, where importer pods are the
+// only legitimate caller.
+func newServerSecurity(u *GoldenImageUploader, podLabel string, port int32) *serverSecurity {
+	return newServerSecurityWithIngress(u, podLabel, port, true)
+}
+
+// newServerSecurityWithIngress is newServerSecurity with importerOnly
+// explicit: backends whose Push pushes directly from wherever this tool runs
+// (registry, s3) need that caller admitted too, and since it isn't a CDI
+// importer pod and isn't identifiable by a label this code controls, those
+// backends pass importerOnly=false to admit any pod in the namespace instead.
+func newServerSecurityWithIngress(u *GoldenImageUploader, podLabel string, port int32, importerOnly bool) *serverSecurity {
+	return &serverSecurity{
+		u:            u,
+		podLabel:     podLabel,
+		port:         port,
+		importerOnly: importerOnly,
+		saName:       podLabel,
+		secretName:   podLabel + "-auth",
+		policyName:   podLabel,
+	}
+}
+
+// provision creates the ServiceAccount, auth Secret, and NetworkPolicy that
+// lock down an ephemeral image server to the pods legitimately allowed to
+// reach it, and returns the generated auth token.
+func (s *serverSecurity) provision(ctx context.Context) (serverAuthToken, error) {
+	if err := s.createServiceAccount(ctx); err != nil {
+		return serverAuthToken{}, fmt.Errorf("creating server service account: %w", err)
+	}
+
+	token, err := generateServerAuthToken()
+	if err != nil {
+		return serverAuthToken{}, err
+	}
+
+	if err := s.createAuthSecret(ctx, token); err != nil {
+		return serverAuthToken{}, fmt.Errorf("creating server auth secret: %w", err)
+	}
+
+	if err := s.createNetworkPolicy(ctx); err != nil {
+		return serverAuthToken{}, fmt.Errorf("creating server network policy: %w", err)
+	}
+
+	return token, nil
+}
+
+// createServiceAccount creates a dedicated, non-default ServiceAccount for
+// the image server pod with its token automount disabled, since the pod has
+// no need to talk to the API server.
+func (s *serverSecurity) createServiceAccount(ctx context.Context) error {
+	automount := false
+	sa := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      s.saName,
+			Namespace: s.u.namespace,
+		},
+		AutomountServiceAccountToken: &automount,
+	}
+
+	_, err := s.u.k8sClient.CoreV1().ServiceAccounts(s.u.namespace).Create(ctx, sa, metav1.CreateOptions{})
+	if err != nil && !k8serrors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// createAuthSecret stores the generated credential both as
+// accessKeyId/secretKey, the form CDI's secretRef fields expect, and as a
+// pre-rendered Basic Auth header for servers that check it directly.
+func (s *serverSecurity) createAuthSecret(ctx context.Context, token serverAuthToken) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      s.secretName,
+			Namespace: s.u.namespace,
+		},
+		Type: corev1.SecretTypeOpaque,
+		StringData: map[string]string{
+			"accessKeyId":   token.accessKeyID,
+			"secretKey":     token.secretKey,
+			"authorization": token.basicAuthHeader(),
+		},
+	}
+
+	_, err := s.u.k8sClient.CoreV1().Secrets(s.u.namespace).Create(ctx, secret, metav1.CreateOptions{})
+	if err != nil && !k8serrors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// createNetworkPolicy restricts ingress to the image server pod, on the
+// server port, and denies all egress from the pod entirely. When
+// importerOnly is set, ingress is restricted to any pod carrying the CDI
+// importer label (not a specific DataVolume's importer: a shared server
+// serving a batch upload is reached by one importer pod per DataVolume, none
+// of which is named after this uploader's own, possibly empty, pvcName).
+// Otherwise every pod in the namespace is admitted, for backends whose Push
+// itself needs to reach the server and can't be distinguished from other
+// pods by label.
+func (s *serverSecurity) createNetworkPolicy(ctx context.Context) error {
+	tcp := corev1.ProtocolTCP
+	port := intstr.FromInt32(s.port)
+
+	from := &metav1.LabelSelector{}
+	if s.importerOnly {
+		from = &metav1.LabelSelector{
+			MatchLabels: map[string]string{cdiImporterLabelKey: cdiImporterLabelValue},
+		}
+	}
+
+	policy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      s.policyName,
+			Namespace: s.u.namespace,
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": s.podLabel},
+			},
+			PolicyTypes: []networkingv1.PolicyType{
+				networkingv1.PolicyTypeIngress,
+				networkingv1.PolicyTypeEgress,
+			},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{{
+				From: []networkingv1.NetworkPolicyPeer{{
+					PodSelector: from,
+				}},
+				Ports: []networkingv1.NetworkPolicyPort{{
+					Protocol: &tcp,
+					Port:     &port,
+				}},
+			}},
+			Egress: []networkingv1.NetworkPolicyEgressRule{},
+		},
+	}
+
+	_, err := s.u.k8sClient.NetworkingV1().NetworkPolicies(s.u.namespace).Create(ctx, policy, metav1.CreateOptions{})
+	if err != nil && !k8serrors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// cleanup removes the ServiceAccount, auth Secret, and NetworkPolicy
+// created by provision. Errors are logged, not returned, matching the
+// best-effort cleanup used for the pod and service themselves.
+func (s *serverSecurity) cleanup(ctx context.Context) {
+	if err := s.u.k8sClient.NetworkingV1().NetworkPolicies(s.u.namespace).Delete(ctx, s.policyName, metav1.DeleteOptions{}); err != nil {
+		if !k8serrors.IsNotFound(err) {
+			fmt.Printf("Warning: failed to delete network policy: %v\n", err)
+		}
+	}
+
+	if err := s.u.k8sClient.CoreV1().Secrets(s.u.namespace).Delete(ctx, s.secretName, metav1.DeleteOptions{}); err != nil {
+		if !k8serrors.IsNotFound(err) {
+			fmt.Printf("Warning: failed to delete auth secret: %v\n", err)
+		}
+	}
+
+	if err := s.u.k8sClient.CoreV1().ServiceAccounts(s.u.namespace).Delete(ctx, s.saName, metav1.DeleteOptions{}); err != nil {
+		if !k8serrors.IsNotFound(err) {
+			fmt.Printf("Warning: failed to delete service account: %v\n", err)
+		}
+	}
+}
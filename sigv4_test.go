@@ -0,0 +1,100 @@
+package goldenimage
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCanonicalURI(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{"empty path becomes root", "", "/"},
+		{"root stays root", "/", "/"},
+		{"object key path is unchanged", "/golden-images/disk.qcow2", "/golden-images/disk.qcow2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := canonicalURI(tt.path); got != tt.want {
+				t.Errorf("canonicalURI(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanonicalizeHeaders(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPut, "http://mcs-image-s3.ns.svc.cluster.local:9000/bucket/key", nil)
+	if err != nil {
+		t.Fatalf("building request: %v", err)
+	}
+	req.Header.Set("Host", "mcs-image-s3.ns.svc.cluster.local:9000")
+	req.Header.Set("X-Amz-Date", "20250101T000000Z")
+	req.Header.Set("X-Amz-Content-Sha256", "abc123")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("User-Agent", "should-not-be-signed")
+
+	canonical, signed := canonicalizeHeaders(req)
+
+	wantSigned := "content-type;host;x-amz-content-sha256;x-amz-date"
+	if signed != wantSigned {
+		t.Errorf("signedHeaders = %q, want %q", signed, wantSigned)
+	}
+	if strings.Contains(canonical, "user-agent") {
+		t.Errorf("canonicalHeaders unexpectedly includes an unsigned header: %q", canonical)
+	}
+	for _, want := range []string{"host:mcs-image-s3.ns.svc.cluster.local:9000\n", "x-amz-date:20250101T000000Z\n"} {
+		if !strings.Contains(canonical, want) {
+			t.Errorf("canonicalHeaders missing %q, got %q", want, canonical)
+		}
+	}
+}
+
+func TestSignSigV4SetsAuthorizationHeader(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPut, "http://mcs-image-s3.ns.svc.cluster.local:9000/bucket/key", nil)
+	if err != nil {
+		t.Fatalf("building request: %v", err)
+	}
+	creds := sigv4Credentials{AccessKeyID: "testaccesskey", SecretAccessKey: "testsecretkey", Region: "us-east-1", Service: "s3"}
+
+	signSigV4(req, []byte("payload"), creds)
+
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 ") {
+		t.Errorf("Authorization header missing algorithm prefix: %q", auth)
+	}
+	if !strings.Contains(auth, "Credential="+creds.AccessKeyID+"/") {
+		t.Errorf("Authorization header missing expected Credential: %q", auth)
+	}
+	if !strings.Contains(auth, "SignedHeaders=") {
+		t.Errorf("Authorization header missing SignedHeaders: %q", auth)
+	}
+	if !strings.Contains(auth, "Signature=") {
+		t.Errorf("Authorization header missing Signature: %q", auth)
+	}
+
+	if req.Header.Get("X-Amz-Date") == "" {
+		t.Error("X-Amz-Date header not set")
+	}
+	if req.Header.Get("X-Amz-Content-Sha256") == "" {
+		t.Error("X-Amz-Content-Sha256 header not set")
+	}
+}
+
+func TestSigv4SigningKeyIsDeterministic(t *testing.T) {
+	creds := sigv4Credentials{AccessKeyID: "ak", SecretAccessKey: "sk", Region: "us-east-1", Service: "s3"}
+
+	first := sigv4SigningKey(creds, "20250101")
+	second := sigv4SigningKey(creds, "20250101")
+	if string(first) != string(second) {
+		t.Error("sigv4SigningKey is not deterministic for identical inputs")
+	}
+
+	differentDate := sigv4SigningKey(creds, "20250102")
+	if string(first) == string(differentDate) {
+		t.Error("sigv4SigningKey produced the same key for different dates")
+	}
+}
@@ -1,38 +1,27 @@
 package goldenimage
 
 import (
-	"archive/tar"
 	"context"
 	"fmt"
-	"io"
 	"os"
 	"time"
 
-	corev1 "k8s.io/api/core/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/remotecommand"
-)
-
-const (
-	serverPodName = "mcs-image-server"
-	serverSvcName = "mcs-image-server"
-	serverPort    = 80
 )
 
 // DataVolume phase constants (matching CDI)
 const (
-	DVPhaseSucceeded = "Succeeded"
-	DVPhaseFailed    = "Failed"
+	DVPhaseUploadReady = "UploadReady"
+	DVPhaseSucceeded   = "Succeeded"
+	DVPhaseFailed      = "Failed"
 )
 
 // GVRs for dynamic client operations
@@ -52,6 +41,18 @@ var (
 		Version:  "v1",
 		Resource: "userdefinednetworks",
 	}
+	uploadTokenRequestGVR = schema.GroupVersionResource{
+		Group:    "cdi.kubevirt.io",
+		Version:  "v1beta1",
+		Resource: "uploadtokenrequests",
+	}
+)
+
+// Defaults for discovering the CDI upload proxy when --upload-proxy-url is
+// not given.
+const (
+	defaultUploadProxyNamespace = "cdi"
+	defaultUploadProxyService   = "cdi-uploadproxy"
 )
 
 // GoldenImageUploader handles golden image uploads to namespaces,
@@ -64,6 +65,59 @@ type GoldenImageUploader struct {
 	pvcName       string
 	pvcSize       string
 	storageClass  string
+
+	// chunkSize and chunkWorkers configure the chunked upload used by
+	// streamImageToPod; zero values fall back to the package defaults.
+	chunkSize    int64
+	chunkWorkers int
+
+	// imageChecksum is populated once StreamImageChunked has verified the
+	// full transfer, and is surfaced to CDI via the DataVolume annotations.
+	imageChecksum string
+
+	// uploadProxyURL overrides discovery of the CDI upload proxy Service,
+	// e.g. when it isn't reachable via in-cluster DNS from where this tool
+	// runs. insecureTLS skips verifying the proxy's TLS certificate.
+	uploadProxyURL string
+	insecureTLS    bool
+
+	// imageServer backs the UDN HTTP-source workflow; nil falls back to the
+	// default nginx-based server. Set via WithImageServer.
+	imageServer ImageServer
+}
+
+// Option configures optional GoldenImageUploader behavior beyond the
+// required constructor arguments.
+type Option func(*GoldenImageUploader)
+
+// WithImageServer selects the ImageServer backend used for the UDN
+// HTTP-source workflow instead of the default ephemeral nginx server.
+func WithImageServer(s ImageServer) Option {
+	return func(u *GoldenImageUploader) {
+		u.imageServer = s
+	}
+}
+
+// WithSourceBackend selects the ImageServer backend by name: "http" (the
+// default ephemeral nginx server), "registry", or "s3". It returns an error
+// for any other value so callers (e.g. a --source-backend flag) can report
+// it before the uploader is constructed.
+//
+// The "registry" and "s3" backends push over plain HTTP to their Service
+// instead of through an exec session into the pod, so unlike "http" they
+// need this tool itself to have in-cluster network reachability to the
+// target namespace; see ociRegistryServer and s3Server.
+func WithSourceBackend(backend string) (Option, error) {
+	switch backend {
+	case "", "http":
+		return func(u *GoldenImageUploader) { u.imageServer = newNginxServer(u) }, nil
+	case "registry":
+		return func(u *GoldenImageUploader) { u.imageServer = newOCIRegistryServer(u) }, nil
+	case "s3":
+		return func(u *GoldenImageUploader) { u.imageServer = newS3Server(u) }, nil
+	default:
+		return nil, fmt.Errorf("unknown source backend %q: want http, registry, or s3", backend)
+	}
 }
 
 // NewGoldenImageUploader creates a new uploader instance with all required clients.
@@ -73,6 +127,11 @@ func NewGoldenImageUploader(
 	pvcName string,
 	pvcSize string,
 	storageClass string,
+	chunkSizeBytes int64,
+	chunkWorkers int,
+	uploadProxyURL string,
+	insecureTLS bool,
+	opts ...Option,
 ) (*GoldenImageUploader, error) {
 	k8sClient, err := kubernetes.NewForConfig(restConfig)
 	if err != nil {
@@ -84,15 +143,25 @@ func NewGoldenImageUploader(
 		return nil, fmt.Errorf("creating dynamic client: %w", err)
 	}
 
-	return &GoldenImageUploader{
-		k8sClient:     k8sClient,
-		dynamicClient: dynamicClient,
-		restConfig:    restConfig,
-		namespace:     namespace,
-		pvcName:       pvcName,
-		pvcSize:       pvcSize,
-		storageClass:  storageClass,
-	}, nil
+	u := &GoldenImageUploader{
+		k8sClient:      k8sClient,
+		dynamicClient:  dynamicClient,
+		restConfig:     restConfig,
+		namespace:      namespace,
+		pvcName:        pvcName,
+		pvcSize:        pvcSize,
+		storageClass:   storageClass,
+		chunkSize:      chunkSizeBytes,
+		chunkWorkers:   chunkWorkers,
+		uploadProxyURL: uploadProxyURL,
+		insecureTLS:    insecureTLS,
+	}
+
+	for _, opt := range opts {
+		opt(u)
+	}
+
+	return u, nil
 }
 
 // Upload handles the complete golden image upload workflow.
@@ -103,6 +172,16 @@ func (u *GoldenImageUploader) Upload(ctx context.Context, localImagePath string)
 		return fmt.Errorf("local image not found: %w", err)
 	}
 
+	if u.pvcSize == "" {
+		sizeInfo, err := InspectImageSize(ctx, u.dynamicClient, localImagePath, u.storageClass)
+		if err != nil {
+			return fmt.Errorf("sizing PVC: %w", err)
+		}
+		fmt.Printf("Auto-sizing PVC: %s image, virtual size %d bytes, %.1f%% overhead -> %s\n",
+			sizeInfo.Format, sizeInfo.VirtualSize, sizeInfo.Overhead*100, sizeInfo.RecommendedSize)
+		u.pvcSize = sizeInfo.RecommendedSize
+	}
+
 	// Detect if namespace uses Primary UDN
 	hasUDN, err := u.namespaceHasPrimaryUDN(ctx)
 	if err != nil {
@@ -271,30 +350,41 @@ func (u *GoldenImageUploader) selectorMatchesNamespace(spec map[string]interface
 	return selector.Matches(labels.Set(nsLabels))
 }
 
-// uploadViaHTTPSource implements the HTTP source workflow for UDN namespaces.
+// uploadViaHTTPSource implements the source-backed workflow for UDN
+// namespaces: it prepares the configured ImageServer, pushes the local
+// image through it, then creates a DataVolume using whatever source spec
+// the backend returns.
 func (u *GoldenImageUploader) uploadViaHTTPSource(ctx context.Context, localImagePath string) error {
-	// Create ephemeral nginx pod
-	fmt.Println("Creating ephemeral image server pod...")
-	if err := u.createServerPod(ctx); err != nil {
-		return fmt.Errorf("creating server pod: %w", err)
+	server := u.imageServer
+	if server == nil {
+		server = newNginxServer(u)
 	}
-	defer u.cleanup(ctx)
 
-	// Create service
-	fmt.Println("Creating image server service...")
-	if err := u.createServerService(ctx); err != nil {
-		return fmt.Errorf("creating server service: %w", err)
+	fmt.Println("Preparing image server backend...")
+	if err := server.Prepare(ctx); err != nil {
+		return fmt.Errorf("preparing image server: %w", err)
 	}
+	defer server.Cleanup(ctx)
 
-	// Stream image to pod
-	fmt.Printf("Streaming image %s to pod...\n", localImagePath)
-	if err := u.streamImageToPod(ctx, localImagePath); err != nil {
-		return fmt.Errorf("streaming image: %w", err)
+	file, err := os.Open(localImagePath)
+	if err != nil {
+		return fmt.Errorf("opening local file: %w", err)
 	}
+	defer file.Close()
 
-	// Create DataVolume with HTTP source
-	fmt.Println("Creating DataVolume with HTTP source...")
-	if err := u.createDataVolume(ctx); err != nil {
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("stat local file: %w", err)
+	}
+
+	fmt.Printf("Pushing image %s to image server...\n", localImagePath)
+	source, err := server.Push(ctx, file, info.Size())
+	if err != nil {
+		return fmt.Errorf("pushing image: %w", err)
+	}
+
+	fmt.Println("Creating DataVolume...")
+	if err := u.createDataVolumeWithSource(ctx, source); err != nil {
 		return fmt.Errorf("creating DataVolume: %w", err)
 	}
 
@@ -308,196 +398,85 @@ func (u *GoldenImageUploader) uploadViaHTTPSource(ctx context.Context, localImag
 	return nil
 }
 
-// uploadViaProxy implements the standard CDI upload proxy workflow.
-// This is a placeholder - integrate with existing virtctl-style upload logic.
+// uploadViaProxy implements the standard CDI upload proxy workflow: create a
+// DataVolume with an upload source, request a short-lived upload token, and
+// POST the image directly to the CDI upload proxy. This makes the tool
+// self-contained for non-UDN namespaces without requiring virtctl.
 func (u *GoldenImageUploader) uploadViaProxy(ctx context.Context, localImagePath string) error {
-	// TODO: Implement standard upload flow using CDI uploadproxy
-	// This would mirror the logic in virtctl's imageupload.go
-	return fmt.Errorf("standard upload flow not implemented - use virtctl image-upload for non-UDN namespaces")
-}
-
-// createServerPod creates an ephemeral nginx pod to serve the image.
-func (u *GoldenImageUploader) createServerPod(ctx context.Context) error {
-	pod := &corev1.Pod{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      serverPodName,
-			Namespace: u.namespace,
-			Labels:    map[string]string{"app": serverPodName},
-		},
-		Spec: corev1.PodSpec{
-			Containers: []corev1.Container{{
-				Name:  "nginx",
-				Image: "nginx:alpine",
-				Ports: []corev1.ContainerPort{{
-					ContainerPort: serverPort,
-					Protocol:      corev1.ProtocolTCP,
-				}},
-				Command: []string{"sh", "-c", "mkdir -p /usr/share/nginx/html && nginx -g 'daemon off;'"},
-				ReadinessProbe: &corev1.Probe{
-					ProbeHandler: corev1.ProbeHandler{
-						TCPSocket: &corev1.TCPSocketAction{
-							Port: intstr.FromInt32(serverPort),
-						},
-					},
-					InitialDelaySeconds: 2,
-					PeriodSeconds:       2,
-				},
-			}},
-			RestartPolicy: corev1.RestartPolicyNever,
-		},
-	}
-
-	_, err := u.k8sClient.CoreV1().Pods(u.namespace).Create(ctx, pod, metav1.CreateOptions{})
-	if err != nil && !k8serrors.IsAlreadyExists(err) {
-		return err
-	}
-
-	// Wait for pod ready
-	return wait.PollUntilContextTimeout(ctx, 2*time.Second, 120*time.Second, true,
-		func(ctx context.Context) (bool, error) {
-			p, err := u.k8sClient.CoreV1().Pods(u.namespace).Get(ctx, serverPodName, metav1.GetOptions{})
-			if err != nil {
-				return false, err
-			}
-			for _, cond := range p.Status.Conditions {
-				if cond.Type == corev1.PodReady && cond.Status == corev1.ConditionTrue {
-					return true, nil
-				}
-			}
-			return false, nil
-		})
-}
-
-// createServerService creates a ClusterIP service for the image server pod.
-func (u *GoldenImageUploader) createServerService(ctx context.Context) error {
-	svc := &corev1.Service{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      serverSvcName,
-			Namespace: u.namespace,
-		},
-		Spec: corev1.ServiceSpec{
-			Selector: map[string]string{"app": serverPodName},
-			Ports: []corev1.ServicePort{{
-				Port:       serverPort,
-				TargetPort: intstr.FromInt32(serverPort),
-				Protocol:   corev1.ProtocolTCP,
-			}},
-			Type: corev1.ServiceTypeClusterIP,
-		},
-	}
-
-	_, err := u.k8sClient.CoreV1().Services(u.namespace).Create(ctx, svc, metav1.CreateOptions{})
-	if err != nil && !k8serrors.IsAlreadyExists(err) {
-		return err
-	}
-	return nil
-}
-
-// streamImageToPod streams the local image file to the nginx pod via exec/tar.
-func (u *GoldenImageUploader) streamImageToPod(ctx context.Context, localImagePath string) error {
-	// Open local file
 	file, err := os.Open(localImagePath)
 	if err != nil {
 		return fmt.Errorf("opening local file: %w", err)
 	}
 	defer file.Close()
 
-	fileInfo, err := file.Stat()
+	info, err := file.Stat()
 	if err != nil {
 		return fmt.Errorf("stat local file: %w", err)
 	}
 
-	fmt.Printf("Image size: %d bytes (%.2f GB)\n", fileInfo.Size(), float64(fileInfo.Size())/(1024*1024*1024))
-
-	// Create tar stream (kubectl cp protocol)
-	reader, writer := io.Pipe()
-
-	// Write tar in goroutine
-	errChan := make(chan error, 1)
-	go func() {
-		defer writer.Close()
-		tw := tar.NewWriter(writer)
-		defer tw.Close()
+	fmt.Println("Creating DataVolume with upload source...")
+	if err := u.createUploadDataVolume(ctx); err != nil {
+		return fmt.Errorf("creating DataVolume: %w", err)
+	}
 
-		header := &tar.Header{
-			Name: "disk.qcow2",
-			Mode: 0644,
-			Size: fileInfo.Size(),
-		}
-		if err := tw.WriteHeader(header); err != nil {
-			errChan <- fmt.Errorf("writing tar header: %w", err)
-			return
-		}
+	fmt.Println("Waiting for DataVolume to become UploadReady...")
+	if err := u.waitForDataVolumePhase(ctx, DVPhaseUploadReady, 5*time.Minute); err != nil {
+		return fmt.Errorf("waiting for UploadReady: %w", err)
+	}
 
-		written, err := io.Copy(tw, file)
-		if err != nil {
-			errChan <- fmt.Errorf("copying file to tar: %w", err)
-			return
-		}
-		fmt.Printf("Wrote %d bytes to tar stream\n", written)
-		errChan <- nil
-	}()
-
-	// Execute tar extract in pod
-	req := u.k8sClient.CoreV1().RESTClient().Post().
-		Resource("pods").
-		Name(serverPodName).
-		Namespace(u.namespace).
-		SubResource("exec").
-		VersionedParams(&corev1.PodExecOptions{
-			Container: "nginx",
-			Command:   []string{"tar", "-xf", "-", "-C", "/usr/share/nginx/html"},
-			Stdin:     true,
-			Stdout:    true,
-			Stderr:    true,
-		}, scheme.ParameterCodec)
-
-	exec, err := remotecommand.NewSPDYExecutor(u.restConfig, "POST", req.URL())
+	fmt.Println("Requesting upload token...")
+	token, err := u.createUploadTokenRequest(ctx)
 	if err != nil {
-		return fmt.Errorf("creating executor: %w", err)
+		return fmt.Errorf("requesting upload token: %w", err)
 	}
 
-	err = exec.StreamWithContext(ctx, remotecommand.StreamOptions{
-		Stdin:  reader,
-		Stdout: os.Stdout,
-		Stderr: os.Stderr,
-	})
+	proxyURL, err := u.discoverUploadProxyURL(ctx)
 	if err != nil {
-		return fmt.Errorf("streaming to pod: %w", err)
+		return fmt.Errorf("discovering upload proxy: %w", err)
+	}
+
+	fmt.Printf("Uploading %s to %s...\n", localImagePath, proxyURL)
+	if err := u.postImageToUploadProxy(ctx, proxyURL, token, file, info.Size()); err != nil {
+		return fmt.Errorf("uploading to proxy: %w", err)
 	}
 
-	// Check for tar write errors
-	if tarErr := <-errChan; tarErr != nil {
-		return tarErr
+	fmt.Println("Waiting for DataVolume to complete...")
+	if err := u.waitForDataVolumePhase(ctx, DVPhaseSucceeded, 60*time.Minute); err != nil {
+		return fmt.Errorf("waiting for DataVolume: %w", err)
 	}
 
+	fmt.Printf("Golden image %s created successfully\n", u.pvcName)
 	return nil
 }
 
-// createDataVolume creates a DataVolume with HTTP source pointing to the ephemeral server.
-// Uses dynamic client to avoid CDI typed client dependency issues.
-func (u *GoldenImageUploader) createDataVolume(ctx context.Context) error {
-	httpURL := fmt.Sprintf("http://%s.%s.svc.cluster.local:%d/disk.qcow2",
-		serverSvcName, u.namespace, serverPort)
+// annotationsForDataVolume builds the DataVolume annotations, including the
+// CDI end-to-end checksum when a verified SHA-256 is available.
+func annotationsForDataVolume(imageChecksum string) map[string]interface{} {
+	annotations := map[string]interface{}{
+		"cdi.kubevirt.io/storage.bind.immediate.requested": "", // Force immediate binding
+	}
+	if imageChecksum != "" {
+		annotations["cdi.kubevirt.io/storage.checksum"] = imageChecksum
+	}
+	return annotations
+}
 
-	// Build DataVolume as unstructured object
+// createDataVolumeWithSource creates a DataVolume whose spec.source is
+// exactly what an ImageServer's Push returned, so the DataVolume workflow
+// doesn't need to know which backend (nginx, registry, S3, ...) served it.
+// Uses dynamic client to avoid CDI typed client dependency issues.
+func (u *GoldenImageUploader) createDataVolumeWithSource(ctx context.Context, source map[string]interface{}) error {
 	dv := &unstructured.Unstructured{
 		Object: map[string]interface{}{
 			"apiVersion": "cdi.kubevirt.io/v1beta1",
 			"kind":       "DataVolume",
 			"metadata": map[string]interface{}{
-				"name":      u.pvcName,
-				"namespace": u.namespace,
-				"annotations": map[string]interface{}{
-					"cdi.kubevirt.io/storage.bind.immediate.requested": "", // Force immediate binding
-				},
+				"name":        u.pvcName,
+				"namespace":   u.namespace,
+				"annotations": annotationsForDataVolume(u.imageChecksum),
 			},
 			"spec": map[string]interface{}{
-				"source": map[string]interface{}{
-					"http": map[string]interface{}{
-						"url": httpURL,
-					},
-				},
+				"source": source,
 				"storage": map[string]interface{}{
 					"resources": map[string]interface{}{
 						"requests": map[string]interface{}{
@@ -527,9 +506,15 @@ func (u *GoldenImageUploader) createDataVolume(ctx context.Context) error {
 // waitForDataVolume waits for the DataVolume to reach Succeeded phase.
 // Uses dynamic client to avoid CDI typed client dependency issues.
 func (u *GoldenImageUploader) waitForDataVolume(ctx context.Context) error {
+	return u.waitForDataVolumePhase(ctx, DVPhaseSucceeded, 60*time.Minute)
+}
+
+// waitForDataVolumePhase polls the DataVolume until it reaches want or fails.
+// Uses dynamic client to avoid CDI typed client dependency issues.
+func (u *GoldenImageUploader) waitForDataVolumePhase(ctx context.Context, want string, timeout time.Duration) error {
 	var lastPhase string
 
-	return wait.PollUntilContextTimeout(ctx, 5*time.Second, 60*time.Minute, true,
+	return wait.PollUntilContextTimeout(ctx, 5*time.Second, timeout, true,
 		func(ctx context.Context) (bool, error) {
 			dv, err := u.dynamicClient.Resource(dataVolumeGVR).Namespace(u.namespace).Get(ctx, u.pvcName, metav1.GetOptions{})
 			if err != nil {
@@ -552,44 +537,9 @@ func (u *GoldenImageUploader) waitForDataVolume(ctx context.Context) error {
 
 			// Check for failure
 			if phase == DVPhaseFailed {
-				conditions, _ := status["conditions"].([]interface{})
-				return false, fmt.Errorf("DataVolume failed: %v", conditions)
+				return false, &DataVolumePhaseError{Name: u.pvcName, Phase: phase, Wanted: want}
 			}
 
-			return phase == DVPhaseSucceeded, nil
+			return phase == want, nil
 		})
 }
-
-// cleanup removes the ephemeral pod and service.
-func (u *GoldenImageUploader) cleanup(ctx context.Context) {
-	fmt.Println("Cleaning up ephemeral resources...")
-
-	// Delete service (ignore errors)
-	if err := u.k8sClient.CoreV1().Services(u.namespace).Delete(ctx, serverSvcName, metav1.DeleteOptions{}); err != nil {
-		if !k8serrors.IsNotFound(err) {
-			fmt.Printf("Warning: failed to delete service: %v\n", err)
-		}
-	}
-
-	// Delete pod (ignore errors)
-	if err := u.k8sClient.CoreV1().Pods(u.namespace).Delete(ctx, serverPodName, metav1.DeleteOptions{}); err != nil {
-		if !k8serrors.IsNotFound(err) {
-			fmt.Printf("Warning: failed to delete pod: %v\n", err)
-		}
-	}
-}
-
-// GetPVCSize parses an image file and returns a recommended PVC size.
-// Adds 20% overhead to account for qcow2 to raw conversion expansion.
-func GetPVCSize(imagePath string) (string, error) {
-	info, err := os.Stat(imagePath)
-	if err != nil {
-		return "", err
-	}
-
-	// Add 20% overhead and round up to nearest Gi
-	sizeBytes := float64(info.Size()) * 1.2
-	sizeGi := int64(sizeBytes/(1024*1024*1024)) + 1
-
-	return fmt.Sprintf("%dGi", sizeGi), nil
-}